@@ -10,9 +10,18 @@ import (
 // Response :
 //
 //	200, {"message" : "pong"}
+//	503, {"message" : "shutting down"}
 func Ping(w http.ResponseWriter, r *http.Request) {
 	workers.Wrkr.Logger.Printf("Request received on health check route")
 
+	// Fail the health check while shutting down so the load balancer stops sending requests
+	if workers.Wrkr.ShuttingDown() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"message": "shutting down"})
+		return
+	}
+
 	// Add critical health checks of different resources like db connections
 	// Meant for future improvements
 	w.Header().Set("Content-Type", "application/json")
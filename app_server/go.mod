@@ -2,4 +2,9 @@ module GoBalance/app_server
 
 go 1.23.1
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/net v0.28.0
+)
+
+require golang.org/x/text v0.17.0 // indirect
@@ -3,8 +3,19 @@ package main
 import (
 	"GoBalance/app_server/controller"
 	"GoBalance/app_server/workers"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
@@ -25,7 +36,87 @@ func main() {
 	http.HandleFunc("/worker/stats", controller.Stats)
 	http.HandleFunc("/ping", controller.Ping)
 
+	// Accept HTTP/2 without TLS (h2c) next to HTTP/1.1, or serve TLS if a certificate is configured
+	server := &http.Server{Addr: ":8080", Handler: h2c.NewHandler(http.DefaultServeMux, &http2.Server{})}
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile != "" {
+		tlsConfig, err := clientVerification(os.Getenv("TLS_CLIENT_CA_FILE"))
+		if err != nil {
+			workers.Wrkr.Logger.Fatal("Error configuring TLS: ", err)
+		}
+		server = &http.Server{Addr: ":8080", TLSConfig: tlsConfig}
+	}
+
 	// Start the server
-	workers.Wrkr.Logger.Println("Server is running on :8080")
-	workers.Wrkr.Logger.Fatal(http.ListenAndServe(":8080", nil))
+	go func() {
+		var err error
+		if certFile != "" {
+			workers.Wrkr.Logger.Println("Server is running on :8080 (TLS)")
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			workers.Wrkr.Logger.Println("Server is running on :8080")
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			workers.Wrkr.Logger.Fatal(err)
+		}
+	}()
+
+	// Run until SIGTERM or SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	<-ctx.Done()
+	stop()
+
+	// Fail the health checks first and keep serving until the load balancer
+	// has noticed, then let the outstanding requests finish
+	workers.Wrkr.StartShutdown()
+	delay := envDuration("SHUTDOWN_DELAY", 15*time.Second)
+	timeout := envDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+	workers.Wrkr.Logger.Printf("Shutting down, failing health checks for %s", delay)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		workers.Wrkr.Logger.Println("Error shutting down server: ", err)
+	}
+	if err := workers.Wrkr.FlushStats(); err != nil {
+		workers.Wrkr.Logger.Println("Error writing stats: ", err)
+	}
+	workers.Wrkr.Logger.Println("Server stopped")
+}
+
+// Function to read a duration from the environment, falling back to the default
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		workers.Wrkr.Logger.Printf("Invalid %s %q: %v. Using %s.", key, value, err, def)
+		return def
+	}
+	return duration
+}
+
+// Function to build the TLS settings of the server. With a CA bundle every
+// client, such as the load balancer, must present a certificate signed by it.
+func clientVerification(caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA bundle %s: %v", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", caFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
 }
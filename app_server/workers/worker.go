@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -21,6 +22,7 @@ type Worker struct {
 	StatsDir       string
 	Logger         *log.Logger
 	Rng            *rand.Rand
+	shuttingDown   atomic.Bool
 }
 
 type Stats struct {
@@ -96,9 +98,36 @@ func (w *Worker) UpdateStats(success bool) {
 	}
 
 	// Write stats to file
-	statsFile := filepath.Join(w.StatsDir, "worker_stats.json")
-	statsJSON, _ := json.Marshal(w.Stats)
-	if err := os.WriteFile(statsFile, statsJSON, 0644); err != nil {
+	if err := w.writeStats(); err != nil {
 		w.Logger.Printf("Failed to write stats to file: %v", err)
 	}
 }
+
+// Synchronized function to write the statistics of the worker node to the stats file.
+// Nothing is written before the first request, so the stats file is left as it is.
+func (w *Worker) FlushStats() error {
+	w.Stats.mutex.Lock()
+	defer w.Stats.mutex.Unlock()
+
+	if w.Stats.TotalRequests == 0 {
+		return nil
+	}
+	return w.writeStats()
+}
+
+// Writes the stats to the stats file, the caller holds the stats lock
+func (w *Worker) writeStats() error {
+	statsFile := filepath.Join(w.StatsDir, "worker_stats.json")
+	statsJSON, _ := json.Marshal(w.Stats)
+	return os.WriteFile(statsFile, statsJSON, 0644)
+}
+
+// Marks the worker node as shutting down, its health check fails from now on
+func (w *Worker) StartShutdown() {
+	w.shuttingDown.Store(true)
+}
+
+// Reports whether the worker node is shutting down
+func (w *Worker) ShuttingDown() bool {
+	return w.shuttingDown.Load()
+}
@@ -16,6 +16,8 @@ type Config struct {
 	StatsDir   string  `yaml:"stats-dir"`
 	Failure    float64 `yaml:"failure"`
 	MaxWorkers int     `yaml:"max_workers"`
+	Strategy   string  `yaml:"strategy"`
+	HashKey    string  `yaml:"hash_key"`
 }
 
 func LoadConfig() {
@@ -116,6 +116,8 @@ EOF
 POOL=%d
 MAX_WORKER=%d
 WORKER=%d
+STRATEGY=%s
+HASH_KEY=%s
 EOF
 		# Provide appropriate permissions
 		chmod +x /home/ubuntu/app/load_balancer
@@ -127,5 +129,5 @@ EOF
 		cd /home/ubuntu/app
 		./load_balancer >> /home/ubuntu/app/load_balancer.log 2>&1 &
 		curl -X POST %s -H 'Content-Type: application/json' -d '{"email": "%s","message": {"subject": "GoBalance Deployed","body": "<p style=\"color: black;\">The deployment process has been <span style=\"color: green;\">completed successfully</span>.</p><p style=\"color: black;\">Check your aws console and get the</p>"}}'
-	`, commonUserDataScript, assetURL, available_nodes.String(), standby_nodes.String(), all_nodes.String(), config.VMConfigs.Pool, config.VMConfigs.MaxWorkers, config.VMConfigs.Worker, os.Getenv("MAIL_API"), os.Getenv("ADMIN_MAIL"))
+	`, commonUserDataScript, assetURL, available_nodes.String(), standby_nodes.String(), all_nodes.String(), config.VMConfigs.Pool, config.VMConfigs.MaxWorkers, config.VMConfigs.Worker, config.VMConfigs.Strategy, config.VMConfigs.HashKey, os.Getenv("MAIL_API"), os.Getenv("ADMIN_MAIL"))
 }
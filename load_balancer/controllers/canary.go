@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"GoBalance/loadbalancer/lib/router"
+	"encoding/json"
+	"net/http"
+)
+
+// Runtime view of a canary split
+type canaryStatus struct {
+	Name   string `json:"name"`
+	Pool   string `json:"pool"`
+	Canary string `json:"canary"`
+	Weight int    `json:"weight"`
+}
+
+// Body of a canary weight change
+type canaryUpdate struct {
+	Name   string `json:"name"`
+	Weight *int   `json:"weight"`
+}
+
+// Canaries handler for the /admin/canaries route.
+// GET lists every canary split, POST changes the weight of one of them.
+func Canaries(hosts *router.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			var update canaryUpdate
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			canary := hosts.Canary(update.Name)
+			if canary == nil {
+				http.Error(w, "Unknown canary: "+update.Name, http.StatusNotFound)
+				return
+			}
+			if update.Weight == nil {
+				http.Error(w, "Missing weight", http.StatusBadRequest)
+				return
+			}
+			if err := canary.SetWeight(*update.Weight); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		canaries := []canaryStatus{}
+		for _, canary := range hosts.Canaries() {
+			canaries = append(canaries, canaryStatus{
+				Name:   canary.Name,
+				Pool:   canary.Pool,
+				Canary: canary.Canary,
+				Weight: canary.Weight(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(canaries)
+	}
+}
@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"GoBalance/loadbalancer/lb"
+	"net/http"
+)
+
+// Proxy returns the handler forwarding requests matched by the route table to the pool.
+// Worker health is tracked by the background health checker, and failed
+// requests are retried on another worker according to the retry policy.
+func Proxy(pool *lb.LoadBalancer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool.Forward(w, r)
+	}
+}
@@ -6,21 +6,57 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
 	"sync"
 )
 
-// Stats handler for forwarding requests on /worker/stats route
+// Stats handler for forwarding requests on /worker/stats route.
+// Reports the totals across all pools and the stats of every pool.
 func Stats(w http.ResponseWriter, r *http.Request) {
+	totalStats := lb.WorkerStats{}
+	pools := make(map[string]interface{})
+
+	for _, pool := range lb.AllPools() {
+		poolResult, poolTotals, err := poolStats(pool)
+		if err != nil {
+			http.Error(w, "Error reading IP addresses: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pools[pool.Name] = poolResult
+		totalStats.SuccessfulRequests += poolTotals.SuccessfulRequests
+		totalStats.FailedRequests += poolTotals.FailedRequests
+		totalStats.TotalRequests += poolTotals.TotalRequests
+	}
+
+	result := map[string]interface{}{
+		"success-request": map[string]int{"total": totalStats.SuccessfulRequests},
+		"failed-request":  map[string]int{"total": totalStats.FailedRequests},
+		"total-request":   map[string]int{"total": totalStats.TotalRequests},
+		"pools":           pools,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Collects the stats of every worker node listed in the all nodes file of the pool
+func poolStats(pool *lb.LoadBalancer) (map[string]interface{}, lb.WorkerStats, error) {
 	stats := make(map[string]interface{})
 	totalStats := lb.WorkerStats{}
 
-	// Read IP addresses from all_nodes.txt
-	ipAddresses, err := file.ReadIPAddresses("all_nodes.txt")
+	// Read IP addresses from the all nodes file of the pool,
+	// pools without one only report their current workers
+	workers := pool.WorkerList()
+	ipAddresses, err := file.ReadIPAddresses(pool.AllFile)
+	if os.IsNotExist(err) {
+		ipAddresses, err = nil, nil
+		for _, worker := range workers {
+			ipAddresses = append(ipAddresses, worker.URL.Hostname())
+		}
+	}
 	if err != nil {
-		http.Error(w, "Error reading IP addresses: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, totalStats, err
 	}
 
 	var wg sync.WaitGroup
@@ -31,28 +67,45 @@ func Stats(w http.ResponseWriter, r *http.Request) {
 		if strings.TrimSpace(ipAddress) == "" {
 			continue
 		}
+		node, err := lb.ParseNode(ipAddress)
+		if err != nil {
+			pool.Logger.Println("Error parsing worker node entry: ", err)
+			continue
+		}
+		ipAddress = node.Address
 		wg.Add(1)
 		go func(i int, ipAddress string) {
 			defer wg.Done()
-			// Find the corresponding worker in lb.LB.Workers
+			parsedURL, err := pool.WorkerURL(ipAddress)
+			if err != nil {
+				// Handle the URL parsing error (log or return)
+				statsChan <- map[string]lb.WorkerStats{fmt.Sprintf("worker%d", i+1): {}}
+				return
+			}
+
+			// Find the corresponding worker in the pool
 			var worker *lb.Worker
-			for _, w := range lb.LB.Workers {
-				if w.URL.String() == fmt.Sprintf("http://%s:8080", ipAddress) {
+			for _, w := range workers {
+				if w.URL.String() == parsedURL.String() {
 					worker = w
 					break
 				}
 			}
 			if worker == nil {
 				// If no matching worker is found, create a dummy worker with the parsed URL
-				parsedURL, err := url.Parse(fmt.Sprintf("http://%s:8080", ipAddress))
-				if err != nil {
-					// Handle the URL parsing error (log or return)
-					statsChan <- map[string]lb.WorkerStats{fmt.Sprintf("worker%d", i+1): {}}
-					return
-				}
 				worker = &lb.Worker{URL: parsedURL}
 			}
-			workerStats := lb.FetchWorkerStats(worker)
+			// Take the load balancer's view before the worker is asked, which may take a while
+			connections := worker.InFlight()
+			drain, draining := pool.DrainProgress(worker)
+			workerStats := pool.FetchWorkerStats(worker)
+			if worker.Breaker != nil {
+				workerStats.Breaker = worker.Breaker.State().String()
+			}
+			workerStats.Connections = connections
+			if draining {
+				workerStats.Draining = &drain
+			}
 			statsChan <- map[string]lb.WorkerStats{fmt.Sprintf("worker%d", i+1): workerStats}
 		}(i, ipAddress)
 	}
@@ -79,13 +132,30 @@ func Stats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Preparing the response
+	breakers := map[string]string{}
+	connections := map[string]int64{}
+	draining := map[string]*lb.DrainStatus{}
 	for workerName, stat := range stats {
 		workerStat := stat.(lb.WorkerStats)
 		result["success-request"].(map[string]int)[workerName] = workerStat.SuccessfulRequests
 		result["failed-request"].(map[string]int)[workerName] = workerStat.FailedRequests
 		result["total-request"].(map[string]int)[workerName] = workerStat.TotalRequests
+		if workerStat.Breaker != "" {
+			breakers[workerName] = workerStat.Breaker
+		}
+		connections[workerName] = workerStat.Connections
+		if workerStat.Draining != nil {
+			draining[workerName] = workerStat.Draining
+		}
+	}
+	result["circuit-breaker"] = breakers
+	result["connections"] = connections
+	result["draining"] = draining
+	result["hedging"] = map[string]int64{
+		"requests": pool.Hedges.Requests.Load(),
+		"fired":    pool.Hedges.Fired.Load(),
+		"won":      pool.Hedges.Won.Load(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	return result, totalStats, nil
 }
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"GoBalance/loadbalancer/lb"
+	"GoBalance/loadbalancer/lib/config"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Runtime view of a worker node
+type workerStatus struct {
+	Pool     string          `json:"pool"`
+	Address  string          `json:"address"`
+	URL      string          `json:"url"`
+	Weight   int             `json:"weight"`
+	State    string          `json:"state"`
+	Healthy  bool            `json:"healthy"`
+	InFlight int64           `json:"in_flight"`
+	Breaker  string          `json:"breaker,omitempty"`
+	Draining *lb.DrainStatus `json:"draining,omitempty"`
+}
+
+// Body of a worker membership change
+type workerUpdate struct {
+	Pool    string `json:"pool"` // defaults to the default pool
+	Address string `json:"address"`
+	Weight  int    `json:"weight"` // only used when adding a worker
+}
+
+// Workers handler for the /admin/workers route.
+// GET lists the worker nodes of every pool, POST adds a worker node and DELETE
+// removes one, moving it to the standby nodes.
+func Workers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		update, pool, ok := decodeWorkerUpdate(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		if r.Method == http.MethodPost {
+			err = pool.AddNode(lb.Node{Address: update.Address, Weight: max(update.Weight, 1)}.String())
+		} else {
+			err = pool.RemoveNode(update.Address)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), workerErrorStatus(pool, update.Address, r.Method))
+			return
+		}
+	}
+
+	workers := []workerStatus{}
+	for _, pool := range lb.AllPools() {
+		for _, worker := range pool.WorkerList() {
+			workers = append(workers, newWorkerStatus(pool, worker))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workers)
+}
+
+// WorkerAction handler for the /admin/workers/drain, /enable and /disable routes
+func WorkerAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		update, pool, ok := decodeWorkerUpdate(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		switch action {
+		case "drain":
+			err = pool.DrainNode(update.Address)
+		case "enable":
+			err = pool.EnableNode(update.Address)
+		case "disable":
+			err = pool.DisableNode(update.Address)
+		default:
+			http.Error(w, "Unknown action: "+action, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		worker := pool.FindWorker(update.Address)
+		if worker == nil {
+			// The drain finished right away
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newWorkerStatus(pool, worker))
+	}
+}
+
+// Reads the body of a membership change and looks up its pool
+func decodeWorkerUpdate(w http.ResponseWriter, r *http.Request) (workerUpdate, *lb.LoadBalancer, bool) {
+	var update workerUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return update, nil, false
+	}
+	update.Address = strings.TrimSpace(update.Address)
+	if update.Address == "" {
+		http.Error(w, "Missing address", http.StatusBadRequest)
+		return update, nil, false
+	}
+	if update.Pool == "" {
+		update.Pool = config.DefaultPool
+	}
+	pool := lb.GetPool(update.Pool)
+	if pool == nil {
+		http.Error(w, "Unknown pool: "+update.Pool, http.StatusNotFound)
+		return update, nil, false
+	}
+	return update, pool, true
+}
+
+// Status code of a failed membership change
+func workerErrorStatus(pool *lb.LoadBalancer, address, method string) int {
+	exists := pool.FindWorker(address) != nil
+	switch {
+	case method == http.MethodPost && exists:
+		return http.StatusConflict
+	case method == http.MethodDelete && !exists:
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func newWorkerStatus(pool *lb.LoadBalancer, worker *lb.Worker) workerStatus {
+	status := workerStatus{
+		Pool:     pool.Name,
+		Address:  worker.URL.Hostname(),
+		URL:      worker.URL.String(),
+		Weight:   pool.WorkerWeight(worker),
+		State:    worker.State(),
+		Healthy:  worker.Healthy(),
+		InFlight: worker.InFlight(),
+	}
+	if worker.Breaker != nil {
+		status.Breaker = worker.Breaker.State().String()
+	}
+	if drain, ok := pool.DrainProgress(worker); ok {
+		status.Draining = &drain
+	}
+	return status
+}
@@ -1,3 +1,11 @@
 module GoBalance/loadbalancer
 
 go 1.23.1
+
+require (
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/net v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.17.0 // indirect
@@ -0,0 +1,195 @@
+package lb
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Number of buckets the rolling error window is split into
+const breakerBuckets = 10
+
+// State of a circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // traffic flows normally
+	BreakerOpen                         // the worker receives no traffic
+	BreakerHalfOpen                     // a limited number of probe requests are let through
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Settings of the per-worker circuit breakers
+type BreakerConfig struct {
+	Window           time.Duration // rolling window the error rate is computed over
+	MinRequests      int           // requests in the window before the error rate is trusted
+	ErrorPercent     int           // error rate that opens the circuit
+	OpenTimeout      time.Duration // time the circuit stays open before probing
+	HalfOpenRequests int           // probe requests that must succeed to close the circuit
+}
+
+// Function to read the circuit breaker settings from the environment.
+// A window of 0 disables the circuit breakers.
+func LoadBreakerConfig(logger *log.Logger) BreakerConfig {
+	config := BreakerConfig{
+		Window:           EnvDuration(logger, "BREAKER_WINDOW", 10*time.Second),
+		MinRequests:      max(envInt(logger, "BREAKER_MIN_REQUESTS", 20), 1),
+		ErrorPercent:     envInt(logger, "BREAKER_ERROR_PERCENT", 50),
+		OpenTimeout:      EnvDuration(logger, "BREAKER_OPEN_TIMEOUT", 15*time.Second),
+		HalfOpenRequests: max(envInt(logger, "BREAKER_HALF_OPEN_REQUESTS", 3), 1),
+	}
+
+	// Every bucket of the window must be at least a nanosecond wide
+	switch {
+	case config.Window < 0:
+		logger.Printf("Invalid BREAKER_WINDOW %s, it must not be negative. Circuit breakers are disabled.", config.Window)
+		config.Window = 0
+	case config.Window > 0 && config.Window < breakerBuckets:
+		logger.Printf("BREAKER_WINDOW %s is too short, using %s", config.Window, time.Duration(breakerBuckets))
+		config.Window = breakerBuckets
+	}
+	return config
+}
+
+// Request outcomes of one slice of the rolling window
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// CircuitBreaker stops traffic to a worker node whose error rate over the
+// rolling window crosses the threshold, and probes it again after a timeout.
+type CircuitBreaker struct {
+	name    string
+	config  BreakerConfig
+	logger  *log.Logger
+	mux     sync.Mutex
+	state   BreakerState
+	changed time.Time // time of the last state change
+	buckets [breakerBuckets]breakerBucket
+	probes  int // probe requests admitted while half-open
+	passed  int // probe requests that succeeded while half-open
+}
+
+func NewCircuitBreaker(name string, config BreakerConfig, logger *log.Logger) *CircuitBreaker {
+	return &CircuitBreaker{name: name, config: config, logger: logger, changed: time.Now()}
+}
+
+// Current state of the circuit breaker
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	return cb.state
+}
+
+// Ready reports whether the worker node may receive another request.
+// An open circuit turns half-open once its timeout has passed.
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.changed) < cb.config.OpenTimeout {
+			return false
+		}
+		cb.setState(BreakerHalfOpen)
+		return true
+	case BreakerHalfOpen:
+		// Probes that never reported back must not keep the circuit stuck
+		if time.Since(cb.changed) >= cb.config.OpenTimeout {
+			cb.changed = time.Now()
+			cb.probes = cb.passed
+		}
+		return cb.probes < cb.config.HalfOpenRequests
+	}
+	return true
+}
+
+// Dispatched counts a request sent to the worker node, used to limit probes while half-open
+func (cb *CircuitBreaker) Dispatched() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	if cb.state == BreakerHalfOpen {
+		cb.probes++
+	}
+}
+
+// Record counts the outcome of a request proxied to the worker node
+func (cb *CircuitBreaker) Record(success bool) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		if !success {
+			cb.setState(BreakerOpen)
+			return
+		}
+		cb.passed++
+		if cb.passed >= cb.config.HalfOpenRequests {
+			cb.setState(BreakerClosed)
+		}
+		return
+	case BreakerOpen:
+		// Late responses of requests sent before the circuit opened
+		return
+	}
+
+	bucket := cb.currentBucket()
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+
+	total, failures := cb.totals()
+	if total >= cb.config.MinRequests && failures*100 >= total*cb.config.ErrorPercent {
+		cb.setState(BreakerOpen)
+	}
+}
+
+// Returns the bucket for the current time, recycling buckets that fell out of the window
+func (cb *CircuitBreaker) currentBucket() *breakerBucket {
+	width := cb.config.Window / breakerBuckets
+	now := time.Now().Truncate(width)
+	bucket := &cb.buckets[(now.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(now) {
+		*bucket = breakerBucket{start: now}
+	}
+	return bucket
+}
+
+// Sums up the requests recorded within the rolling window
+func (cb *CircuitBreaker) totals() (total, failures int) {
+	oldest := time.Now().Add(-cb.config.Window)
+	for _, bucket := range cb.buckets {
+		if bucket.start.After(oldest) {
+			total += bucket.successes + bucket.failures
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+// Moves the breaker to a new state, must be called with the lock held
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	cb.logger.Printf("Circuit breaker for %s changed from %s to %s", cb.name, cb.state, state)
+	cb.state = state
+	cb.changed = time.Now()
+	cb.probes = 0
+	cb.passed = 0
+	if state == BreakerClosed {
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	}
+}
@@ -0,0 +1,163 @@
+package lb
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestBreakerWindow(t *testing.T) {
+	tests := []struct {
+		env    string
+		window time.Duration
+	}{
+		{"", 10 * time.Second},
+		{"1m", time.Minute},
+		{"0", 0},
+		{"-5s", 0},
+		{"1ns", breakerBuckets},
+		{"9ns", breakerBuckets},
+		{"10ns", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("BREAKER_WINDOW", tt.env)
+			logger := log.New(io.Discard, "", 0)
+			config := LoadBreakerConfig(logger)
+			if config.Window != tt.window {
+				t.Fatalf("window %s, want %s", config.Window, tt.window)
+			}
+
+			// Recording outcomes must not panic on the shortest windows
+			if config.Window > 0 {
+				cb := NewCircuitBreaker("test", config, logger)
+				cb.Record(true)
+				cb.Record(false)
+			}
+		})
+	}
+}
+
+func TestBreakerStateMachine(t *testing.T) {
+	const openTimeout = 30 * time.Millisecond
+	cb := NewCircuitBreaker("test", BreakerConfig{Window: time.Minute, MinRequests: 4, ErrorPercent: 50, OpenTimeout: openTimeout, HalfOpenRequests: 2}, log.New(io.Discard, "", 0))
+	expect := func(step string, state BreakerState) {
+		t.Helper()
+		if cb.State() != state {
+			t.Fatalf("%s: breaker is %s, want %s", step, cb.State(), state)
+		}
+	}
+	// Opens the breaker and waits for the open timeout to pass
+	reopen := func() {
+		t.Helper()
+		for cb.State() == BreakerClosed {
+			cb.Record(false)
+		}
+		expect("failures", BreakerOpen)
+		time.Sleep(openTimeout + 10*time.Millisecond)
+	}
+
+	// Closed until the window has enough requests and the error rate is reached
+	cb.Record(false)
+	cb.Record(false)
+	cb.Record(false)
+	expect("3 of the 4 minimum requests failed", BreakerClosed)
+	cb.Record(true)
+	expect("3 of 4 requests failed", BreakerOpen)
+
+	// Open: no traffic until the timeout, late outcomes change nothing
+	if cb.Ready() {
+		t.Fatal("open breaker lets traffic through")
+	}
+	cb.Record(true)
+	expect("late success while open", BreakerOpen)
+	time.Sleep(openTimeout + 10*time.Millisecond)
+
+	// Half-open: only the configured number of probes is let through
+	if !cb.Ready() {
+		t.Fatal("breaker lets no probe through after the open timeout")
+	}
+	expect("open timeout passed", BreakerHalfOpen)
+	cb.Dispatched()
+	if !cb.Ready() {
+		t.Fatal("half-open breaker refuses the second probe")
+	}
+	cb.Dispatched()
+	if cb.Ready() {
+		t.Fatal("half-open breaker lets a third probe through")
+	}
+	cb.Record(true)
+	expect("first probe passed", BreakerHalfOpen)
+	cb.Record(true)
+	expect("both probes passed", BreakerClosed)
+
+	// Closing forgets the window
+	cb.Record(false)
+	cb.Record(false)
+	cb.Record(false)
+	expect("3 failures after closing", BreakerClosed)
+
+	// A failed probe opens the breaker again
+	reopen()
+	cb.Ready()
+	cb.Dispatched()
+	cb.Record(false)
+	expect("probe failed", BreakerOpen)
+	if cb.Ready() {
+		t.Fatal("reopened breaker lets traffic through")
+	}
+
+	// Probes that never report back free their slots after another timeout
+	time.Sleep(openTimeout + 10*time.Millisecond)
+	cb.Ready()
+	cb.Dispatched()
+	cb.Dispatched()
+	if cb.Ready() {
+		t.Fatal("half-open breaker lets a third probe through")
+	}
+	time.Sleep(openTimeout + 10*time.Millisecond)
+	if !cb.Ready() {
+		t.Fatal("lost probes keep the breaker stuck")
+	}
+	expect("probes lost", BreakerHalfOpen)
+}
+
+func TestNextWorkerSkipsOpenBreaker(t *testing.T) {
+	const openTimeout = 30 * time.Millisecond
+	logger := log.New(io.Discard, "", 0)
+	strategy, _ := NewStrategy(RoundRobin, "")
+	pool := NewLoadBalancer(logger, strategy)
+	pool.Workers = testWorkers(1, 1)
+	broken := pool.Workers[0]
+	broken.Breaker = NewCircuitBreaker("broken", BreakerConfig{Window: time.Minute, MinRequests: 1, ErrorPercent: 50, OpenTimeout: openTimeout, HalfOpenRequests: 2}, logger)
+	broken.Breaker.Record(false)
+
+	// Counts how often the broken worker is picked in 10 requests
+	picks := func() int {
+		n := 0
+		for i := 0; i < 10; i++ {
+			worker := pool.nextWorker(nil, nil)
+			if worker == broken {
+				n++
+			}
+			worker.Done()
+		}
+		return n
+	}
+
+	if n := picks(); n != 0 {
+		t.Fatalf("worker with an open breaker picked %d times", n)
+	}
+	time.Sleep(openTimeout + 10*time.Millisecond)
+	// Half-open, only the probes get through until they report back
+	if n := picks(); n != 2 {
+		t.Fatalf("half-open worker picked %d times, want the 2 probes", n)
+	}
+	broken.Breaker.Record(true)
+	broken.Breaker.Record(true)
+	if n := picks(); n != 5 {
+		t.Errorf("worker with a closed breaker picked %d of 10 times, want 5", n)
+	}
+}
@@ -0,0 +1,55 @@
+package lb
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Reads an integer setting from the environment, falling back to def
+func envInt(logger *log.Logger, key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Printf("Error parsing %s environment variable: %v. Using default value of %d.", key, err, def)
+		return def
+	}
+	return parsed
+}
+
+// Function to read a duration setting such as "5s" or "250ms" from the environment, falling back to def
+func EnvDuration(logger *log.Logger, key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Printf("Error parsing %s environment variable: %v. Using default value of %s.", key, err, def)
+		return def
+	}
+	return parsed
+}
+
+// Reads a duration setting that must be positive, such as the period of a
+// ticker, falling back to def for zero and negative values
+func envPositiveDuration(logger *log.Logger, key string, def time.Duration) time.Duration {
+	parsed := EnvDuration(logger, key, def)
+	if parsed <= 0 {
+		logger.Printf("Invalid %s environment variable: %s is not positive. Using default value of %s.", key, parsed, def)
+		return def
+	}
+	return parsed
+}
+
+// Reads a string setting from the environment, falling back to def
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
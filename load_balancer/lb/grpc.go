@@ -0,0 +1,179 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Method of the standard gRPC health checking protocol
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+	// HealthCheckResponse.ServingStatus of a worker ready for traffic
+	grpcServing = 1
+
+	grpcCodeDeadlineExceeded = 4
+	grpcCodeInternal         = 13
+	grpcCodeUnavailable      = 14
+)
+
+// Reports whether the request is a gRPC call
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// Reports whether the pool talks HTTP/2 to its worker nodes, which gRPC requires
+func (lb *LoadBalancer) speaksHTTP2() bool {
+	switch lb.protocol {
+	case ProtocolH2, ProtocolH2C:
+		return true
+	case "", ProtocolAuto:
+		return lb.scheme == "https"
+	}
+	return false
+}
+
+// Writes an error the load balancer produced itself. gRPC clients get a
+// trailers-only response carrying a gRPC status, everyone else plain text.
+func writeProxyError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !isGRPC(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	code := grpcCodeUnavailable
+	switch status {
+	case http.StatusBadRequest:
+		code = grpcCodeInternal
+	case http.StatusGatewayTimeout:
+		code = grpcCodeDeadlineExceeded
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Calls grpc.health.v1.Health/Check on the worker node, healthy if it answers SERVING
+func (hc *HealthChecker) probeGRPC(worker *Worker) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.config.Timeout)
+	defer cancel()
+
+	body := bytes.NewReader(grpcFrame(healthCheckRequest(hc.config.Service)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, worker.URL.String()+grpcHealthCheckPath, body)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		hc.lb.Logger.Printf("Health check failed for %s: %v", worker.URL, err)
+		return false
+	}
+	defer resp.Body.Close()
+	message, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		hc.lb.Logger.Printf("Health check failed for %s: %v", worker.URL, err)
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	// Errors come either in the trailers or, without a response message, in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if resp.StatusCode != http.StatusOK || status != "0" {
+		hc.lb.Logger.Printf("Health check failed for %s: status %d, grpc-status %q %s", worker.URL, resp.StatusCode, status, resp.Trailer.Get("Grpc-Message"))
+		return false
+	}
+
+	serving, err := parseHealthCheckResponse(message)
+	if err != nil {
+		hc.lb.Logger.Printf("Health check failed for %s: %v", worker.URL, err)
+		return false
+	}
+	if serving != grpcServing {
+		hc.lb.Logger.Printf("Health check failed for %s: serving status %d", worker.URL, serving)
+		return false
+	}
+	return true
+}
+
+// Prefixes a message with the gRPC length-prefixed message header, uncompressed
+func grpcFrame(message []byte) []byte {
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(message)))
+	return append(frame, message...)
+}
+
+// Encodes a grpc.health.v1.HealthCheckRequest, service is field 1.
+// The empty service asks for the health of the whole server.
+func healthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	message := []byte{0x0a}
+	message = binary.AppendUvarint(message, uint64(len(service)))
+	return append(message, service...)
+}
+
+// Decodes the status, field 1, of a framed grpc.health.v1.HealthCheckResponse
+func parseHealthCheckResponse(frame []byte) (uint64, error) {
+	if len(frame) < 5 || frame[0] != 0 {
+		return 0, fmt.Errorf("malformed health check response")
+	}
+	length := binary.BigEndian.Uint32(frame[1:5])
+	message := frame[5:]
+	if uint64(len(message)) < uint64(length) {
+		return 0, fmt.Errorf("truncated health check response")
+	}
+	message = message[:length]
+
+	var status uint64
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, fmt.Errorf("malformed health check response")
+		}
+		message = message[n:]
+
+		// Skip the fields other than the status by their wire type
+		switch tag & 7 {
+		case 0:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, fmt.Errorf("malformed health check response")
+			}
+			if tag>>3 == 1 {
+				status = value
+			}
+			message = message[n:]
+		case 1:
+			if len(message) < 8 {
+				return 0, fmt.Errorf("malformed health check response")
+			}
+			message = message[8:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return 0, fmt.Errorf("malformed health check response")
+			}
+			message = message[n+int(length):]
+		case 5:
+			if len(message) < 4 {
+				return 0, fmt.Errorf("malformed health check response")
+			}
+			message = message[4:]
+		default:
+			return 0, fmt.Errorf("malformed health check response")
+		}
+	}
+	return status, nil
+}
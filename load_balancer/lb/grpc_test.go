@@ -0,0 +1,153 @@
+package lb
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Function to encode a grpc.health.v1.HealthCheckResponse with the given status
+func healthCheckResponse(status uint64) []byte {
+	return append([]byte{0x08}, byte(status))
+}
+
+func TestParseHealthCheckResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  []byte
+		status uint64
+		ok     bool
+	}{
+		{"serving", grpcFrame(healthCheckResponse(1)), 1, true},
+		{"not serving", grpcFrame(healthCheckResponse(2)), 2, true},
+		{"unknown", grpcFrame(nil), 0, true},
+		{"unknown fields skipped", grpcFrame(append([]byte{0x12, 0x03, 'a', 'b', 'c', 0x1d, 0, 0, 0, 0}, healthCheckResponse(1)...)), 1, true},
+		{"trailing frame ignored", append(grpcFrame(healthCheckResponse(1)), grpcFrame(healthCheckResponse(2))...), 1, true},
+		{"empty", nil, 0, false},
+		{"truncated header", []byte{0, 0, 0}, 0, false},
+		{"truncated message", grpcFrame(healthCheckResponse(1))[:6], 0, false},
+		{"compressed", append([]byte{1}, grpcFrame(healthCheckResponse(1))[1:]...), 0, false},
+		{"truncated varint", grpcFrame([]byte{0x08, 0x80}), 0, false},
+		{"truncated field", grpcFrame([]byte{0x12, 0x05, 'a'}), 0, false},
+		{"invalid wire type", grpcFrame([]byte{0x0f}), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := parseHealthCheckResponse(tt.frame)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error %v, want ok %v", err, tt.ok)
+			}
+			if status != tt.status {
+				t.Errorf("status %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestProbeGRPC(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		status  string // grpc-status sent in the trailers
+		headers string // grpc-status sent in the headers, a trailers-only response
+		healthy bool
+	}{
+		{"serving", grpcFrame(healthCheckResponse(grpcServing)), "0", "", true},
+		{"not serving", grpcFrame(healthCheckResponse(2)), "0", "", false},
+		{"truncated frame", grpcFrame(healthCheckResponse(grpcServing))[:5], "0", "", false},
+		{"error status", nil, "14", "", false},
+		{"trailers only", nil, "", "12", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var service []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				service = body
+				w.Header().Set("Content-Type", "application/grpc")
+				if tt.headers != "" {
+					w.Header().Set("Grpc-Status", tt.headers)
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.Header().Set("Trailer", "Grpc-Status")
+				w.Write(tt.body)
+				w.Header().Set("Grpc-Status", tt.status)
+			}))
+			defer server.Close()
+
+			pool := NewLoadBalancer(log.New(io.Discard, "", 0), nil)
+			hc := NewHealthChecker(pool, HealthCheckConfig{Protocol: "grpc", Service: "echo", Timeout: time.Second})
+			target, _ := url.Parse(server.URL)
+			if healthy := hc.probeGRPC(NewWorker(target, 1)); healthy != tt.healthy {
+				t.Errorf("healthy %v, want %v", healthy, tt.healthy)
+			}
+			if want := grpcFrame(healthCheckRequest("echo")); !bytes.Equal(service, want) {
+				t.Errorf("request % x, want % x", service, want)
+			}
+		})
+	}
+}
+
+func TestForwardGRPCTrailers(t *testing.T) {
+	// An h2c worker answering every call with a message and a NOT_FOUND status in the trailers
+	worker := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write(grpcFrame([]byte{0x08, 0x01}))
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "no such thing")
+	}), &http2.Server{}))
+	defer worker.Close()
+
+	strategy, _ := NewStrategy(RoundRobin, "")
+	pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+	pool.protocol = ProtocolH2C
+	transport, err := newTransport(UpstreamTLSConfig{}, ProtocolH2C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Transport = transport
+	pool.Retries.Attempts = 1
+
+	call := func() *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/echo.Echo/Get", bytes.NewReader(grpcFrame(nil)))
+		r.Header.Set("Content-Type", "application/grpc")
+		r.Header.Set("TE", "trailers")
+		rec := httptest.NewRecorder()
+		pool.Forward(rec, r)
+		return rec.Result()
+	}
+
+	// Without workers the load balancer answers with a trailers-only UNAVAILABLE
+	resp := call()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Grpc-Status") != "14" {
+		t.Fatalf("without workers got status %d, grpc-status %q", resp.StatusCode, resp.Header.Get("Grpc-Status"))
+	}
+
+	if err := pool.AddWorker(worker.Listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	resp = call()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, grpcFrame([]byte{0x08, 0x01})) {
+		t.Fatalf("got status %d and body % x", resp.StatusCode, body)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "5" || resp.Trailer.Get("Grpc-Message") != "no such thing" {
+		t.Errorf("trailers %v, want grpc-status 5 and its message", resp.Trailer)
+	}
+}
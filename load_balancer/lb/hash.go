@@ -0,0 +1,168 @@
+package lb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Number of points every unit of weight places on the hash ring
+const replicasPerWeight = 100
+
+// Extracts the routing key from a request
+type keyFunc func(r *http.Request) string
+
+// Consistent hashing over a ring of virtual nodes. Requests with the same key
+// land on the same worker node, and adding or removing a worker only remaps
+// the keys that hashed to that worker's points on the ring.
+type consistentHashStrategy struct {
+	key     keyFunc
+	members []*Worker
+	points  []uint64
+	owners  map[uint64]*Worker
+}
+
+func newConsistentHashStrategy(hashKey string) (*consistentHashStrategy, error) {
+	key, err := parseHashKey(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	return &consistentHashStrategy{key: key}, nil
+}
+
+func (s *consistentHashStrategy) Next(workers []*Worker, r *http.Request, exclude map[*Worker]bool) *Worker {
+	if !s.sameMembers(workers) {
+		s.rebuild(workers)
+	}
+
+	key := ""
+	if r != nil {
+		key = s.key(r)
+		// Requests without the configured attribute still stick by client
+		if key == "" {
+			key = clientIP(r)
+		}
+	}
+
+	// Retries walk on along the ring to the next worker that has not been tried,
+	// the ring itself is always built from the whole pool
+	h := hashKey(key)
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i] >= h })
+	for n := 0; n < len(s.points); n++ {
+		worker := s.owners[s.points[(i+n)%len(s.points)]]
+		if !exclude[worker] {
+			return worker
+		}
+	}
+	return nil
+}
+
+// Checks whether the ring was built for exactly this set of workers
+func (s *consistentHashStrategy) sameMembers(workers []*Worker) bool {
+	if len(workers) != len(s.members) {
+		return false
+	}
+	for i := range workers {
+		if workers[i] != s.members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Forces the ring to be rebuilt on the next request
+func (s *consistentHashStrategy) reset() {
+	s.members = nil
+}
+
+// Places the virtual nodes of every worker on the ring. The points only
+// depend on the worker address, so a worker keeps its place across rebuilds.
+func (s *consistentHashStrategy) rebuild(workers []*Worker) {
+	s.members = append(s.members[:0], workers...)
+	s.points = s.points[:0]
+	s.owners = make(map[uint64]*Worker)
+
+	for _, worker := range workers {
+		weight := worker.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		for i := 0; i < weight*replicasPerWeight; i++ {
+			point := hashKey(worker.URL.Host + "#" + strconv.Itoa(i))
+			if _, taken := s.owners[point]; taken {
+				continue
+			}
+			s.owners[point] = worker
+			s.points = append(s.points, point)
+		}
+	}
+	sort.Slice(s.points, func(i, j int) bool { return s.points[i] < s.points[j] })
+}
+
+// Function to parse the HASH_KEY setting into a key extractor.
+// Supported forms: ip, header:<name>, cookie:<name> and path:<segment>.
+func parseHashKey(spec string) (keyFunc, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch strings.ToLower(kind) {
+	case "", "ip":
+		return clientIP, nil
+	case "header":
+		if arg == "" {
+			return nil, fmt.Errorf("hash key %q is missing the header name", spec)
+		}
+		return func(r *http.Request) string {
+			return r.Header.Get(arg)
+		}, nil
+	case "cookie":
+		if arg == "" {
+			return nil, fmt.Errorf("hash key %q is missing the cookie name", spec)
+		}
+		return func(r *http.Request) string {
+			cookie, err := r.Cookie(arg)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		}, nil
+	case "path":
+		segment, err := strconv.Atoi(arg)
+		if err != nil || segment < 1 {
+			return nil, fmt.Errorf("hash key %q needs a path segment number starting at 1", spec)
+		}
+		return func(r *http.Request) string {
+			segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if segment > len(segments) {
+				return ""
+			}
+			return segments[segment-1]
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown hash key: %s", spec)
+}
+
+// Returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Hashes a key onto the ring. FNV alone clusters keys that only differ in
+// their last bytes, so the result is passed through the murmur3 finalizer.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,189 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings of the active health checks
+type HealthCheckConfig struct {
+	Protocol           string // http, grpc for the standard gRPC health checking protocol or tcp for a plain connect
+	Service            string // service asked for by gRPC health checks, empty for the whole server
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	ExpectedStatus     int
+	HealthyThreshold   int // consecutive successful probes before a worker is marked up
+	UnhealthyThreshold int // consecutive failed probes before a worker is marked down
+}
+
+// Function to read the health check settings from the environment
+func LoadHealthCheckConfig(logger *log.Logger) HealthCheckConfig {
+	return HealthCheckConfig{
+		Protocol:           strings.ToLower(envString("HEALTH_CHECK_PROTOCOL", "http")),
+		Service:            os.Getenv("HEALTH_CHECK_GRPC_SERVICE"),
+		Path:               envString("HEALTH_CHECK_PATH", "/ping"),
+		Interval:           envPositiveDuration(logger, "HEALTH_CHECK_INTERVAL", 5*time.Second),
+		Timeout:            envPositiveDuration(logger, "HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		ExpectedStatus:     envInt(logger, "HEALTH_CHECK_STATUS", http.StatusOK),
+		HealthyThreshold:   max(envInt(logger, "HEALTH_CHECK_HEALTHY_THRESHOLD", 2), 1),
+		UnhealthyThreshold: max(envInt(logger, "HEALTH_CHECK_UNHEALTHY_THRESHOLD", 3), 1),
+	}
+}
+
+// Returns the settings with the values set in the pool configuration applied
+// on top. Negative intervals and timeouts are ignored with a logged error.
+func (c HealthCheckConfig) merge(logger *log.Logger, o config.HealthCheck) HealthCheckConfig {
+	if o.Protocol != "" {
+		c.Protocol = strings.ToLower(o.Protocol)
+	}
+	if o.Service != "" {
+		c.Service = o.Service
+	}
+	if o.Path != "" {
+		c.Path = o.Path
+	}
+	if o.Interval > 0 {
+		c.Interval = o.Interval
+	} else if o.Interval < 0 {
+		logger.Printf("Invalid health check interval %s, it must be positive. Using %s.", o.Interval, c.Interval)
+	}
+	if o.Timeout > 0 {
+		c.Timeout = o.Timeout
+	} else if o.Timeout < 0 {
+		logger.Printf("Invalid health check timeout %s, it must be positive. Using %s.", o.Timeout, c.Timeout)
+	}
+	if o.ExpectedStatus > 0 {
+		c.ExpectedStatus = o.ExpectedStatus
+	}
+	if o.HealthyThreshold > 0 {
+		c.HealthyThreshold = o.HealthyThreshold
+	}
+	if o.UnhealthyThreshold > 0 {
+		c.UnhealthyThreshold = o.UnhealthyThreshold
+	}
+	return c
+}
+
+// HealthChecker probes every worker node of a load balancer in the background
+// and marks them up or down, so requests are never sent to a dead worker.
+type HealthChecker struct {
+	lb     *LoadBalancer
+	config HealthCheckConfig
+	client *http.Client
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewHealthChecker(lb *LoadBalancer, config HealthCheckConfig) *HealthChecker {
+	return &HealthChecker{
+		lb:     lb,
+		config: config,
+		client: &http.Client{Timeout: config.Timeout, Transport: lb.Transport},
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start runs the health checks on the configured interval until Stop is called
+func (hc *HealthChecker) Start() {
+	switch hc.config.Protocol {
+	case "grpc":
+		hc.lb.Logger.Printf("Health checks started: gRPC health of %q every %s", hc.config.Service, hc.config.Interval)
+	case "tcp":
+		hc.lb.Logger.Printf("Health checks started: TCP connect every %s", hc.config.Interval)
+	default:
+		hc.lb.Logger.Printf("Health checks started: GET %s every %s", hc.config.Path, hc.config.Interval)
+	}
+	go func() {
+		ticker := time.NewTicker(hc.config.Interval)
+		defer ticker.Stop()
+
+		hc.checkAll()
+		for {
+			select {
+			case <-ticker.C:
+				hc.checkAll()
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background health checks
+func (hc *HealthChecker) Stop() {
+	hc.once.Do(func() { close(hc.stop) })
+}
+
+// Probes all worker nodes concurrently and waits for the results
+func (hc *HealthChecker) checkAll() {
+	workers := hc.lb.WorkerList()
+
+	var wg sync.WaitGroup
+	for _, worker := range workers {
+		wg.Add(1)
+		go func(worker *Worker) {
+			defer wg.Done()
+			hc.record(worker, hc.probe(worker))
+		}(worker)
+	}
+	wg.Wait()
+}
+
+// Sends a single health check request to the worker node
+func (hc *HealthChecker) probe(worker *Worker) bool {
+	switch hc.config.Protocol {
+	case "grpc":
+		return hc.probeGRPC(worker)
+	case "tcp":
+		return hc.probeTCP(worker)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hc.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, worker.URL.String()+hc.config.Path, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		hc.lb.Logger.Printf("Health check failed for %s: %v", worker.URL, err)
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != hc.config.ExpectedStatus {
+		hc.lb.Logger.Printf("Health check failed for %s: status %d", worker.URL, resp.StatusCode)
+		return false
+	}
+	return true
+}
+
+// Updates the probe streaks of the worker node and flips its state once a threshold is crossed
+func (hc *HealthChecker) record(worker *Worker, success bool) {
+	if success {
+		worker.failures = 0
+		worker.successes++
+		if !worker.Healthy() && worker.successes >= hc.config.HealthyThreshold {
+			worker.healthy.Store(true)
+			hc.lb.Logger.Printf("Worker %s is healthy, adding it back to rotation", worker.URL)
+		}
+		return
+	}
+
+	worker.successes = 0
+	worker.failures++
+	if worker.Healthy() && worker.failures >= hc.config.UnhealthyThreshold {
+		worker.healthy.Store(false)
+		hc.lb.Logger.Printf("Worker %s is unhealthy, removing it from rotation", worker.URL)
+	}
+}
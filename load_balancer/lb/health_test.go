@@ -0,0 +1,113 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckIntervals(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		pool     config.HealthCheck
+		interval time.Duration
+		timeout  time.Duration
+	}{
+		{"defaults", nil, config.HealthCheck{}, 5 * time.Second, 2 * time.Second},
+		{"env", map[string]string{"HEALTH_CHECK_INTERVAL": "1s", "HEALTH_CHECK_TIMEOUT": "500ms"}, config.HealthCheck{}, time.Second, 500 * time.Millisecond},
+		{"zero env", map[string]string{"HEALTH_CHECK_INTERVAL": "0", "HEALTH_CHECK_TIMEOUT": "0s"}, config.HealthCheck{}, 5 * time.Second, 2 * time.Second},
+		{"negative env", map[string]string{"HEALTH_CHECK_INTERVAL": "-1s", "HEALTH_CHECK_TIMEOUT": "-2s"}, config.HealthCheck{}, 5 * time.Second, 2 * time.Second},
+		{"pool", nil, config.HealthCheck{Interval: 3 * time.Second, Timeout: time.Second}, 3 * time.Second, time.Second},
+		{"negative pool", nil, config.HealthCheck{Interval: -time.Second, Timeout: -time.Second}, 5 * time.Second, 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HEALTH_CHECK_INTERVAL", "")
+			t.Setenv("HEALTH_CHECK_TIMEOUT", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			logger := log.New(io.Discard, "", 0)
+			cfg := LoadHealthCheckConfig(logger).merge(logger, tt.pool)
+			if cfg.Interval != tt.interval || cfg.Timeout != tt.timeout {
+				t.Errorf("got interval %s and timeout %s, want %s and %s", cfg.Interval, cfg.Timeout, tt.interval, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestHealthCheckThresholds(t *testing.T) {
+	var status atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer flaky.Close()
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stable.Close()
+
+	strategy, _ := NewStrategy(RoundRobin, "")
+	pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+	for _, server := range []*httptest.Server{flaky, stable} {
+		if err := pool.AddWorker(server.Listener.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	worker := pool.WorkerList()[0]
+	hc := NewHealthChecker(pool, HealthCheckConfig{
+		Path:               "/ping",
+		Timeout:            time.Second,
+		ExpectedStatus:     http.StatusNoContent,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	})
+
+	steps := []struct {
+		status  int // status the flaky worker answers the probe with
+		healthy bool
+	}{
+		{http.StatusNoContent, true},
+		{http.StatusOK, true}, // not the expected status, it counts as a failure
+		{http.StatusServiceUnavailable, true},
+		{http.StatusNoContent, true}, // the streak of failures starts over
+		{http.StatusOK, true},
+		{http.StatusOK, true},
+		{http.StatusOK, false}, // third failure in a row
+		{http.StatusNoContent, false},
+		{http.StatusOK, false}, // the streak of successes starts over
+		{http.StatusNoContent, false},
+		{http.StatusNoContent, true}, // second success in a row
+	}
+	for i, step := range steps {
+		status.Store(int32(step.status))
+		hc.checkAll()
+		if worker.Healthy() != step.healthy {
+			t.Fatalf("step %d: healthy %v after a probe answered with %d, want %v", i+1, worker.Healthy(), step.status, step.healthy)
+		}
+		if !pool.WorkerList()[1].Healthy() {
+			t.Fatalf("step %d: the stable worker was marked down", i+1)
+		}
+
+		// A worker marked down gets no requests
+		for j := 0; j < 4; j++ {
+			picked := pool.nextWorker(nil, nil)
+			picked.Done()
+			if picked == worker && !step.healthy {
+				t.Fatalf("step %d: request sent to the worker marked down", i+1)
+			}
+		}
+	}
+}
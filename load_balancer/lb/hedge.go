@@ -0,0 +1,260 @@
+package lb
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Number of recent response latencies the hedge delay is computed from
+	latencySamples = 1024
+	// Samples needed before hedging starts, the delay is meaningless before that
+	minLatencySamples = 50
+)
+
+// Settings of request hedging
+type HedgeConfig struct {
+	Paths      []string // path prefixes of the GET routes that are hedged, none disables hedging
+	Percentile float64  // latency percentile after which the hedge is sent
+	MinDelay   time.Duration
+}
+
+// Function to read the hedging settings from the environment
+func LoadHedgeConfig(logger *log.Logger) HedgeConfig {
+	config := HedgeConfig{
+		Percentile: float64(envInt(logger, "HEDGE_PERCENTILE", 95)),
+		MinDelay:   EnvDuration(logger, "HEDGE_MIN_DELAY", 10*time.Millisecond),
+	}
+	for _, path := range strings.Split(envString("HEDGE_PATHS", ""), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			config.Paths = append(config.Paths, path)
+		}
+	}
+	return config
+}
+
+// Reports whether requests to the path are hedged
+func (c HedgeConfig) matches(path string) bool {
+	for _, prefix := range c.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Counters of the hedged requests, shown on the stats route
+type HedgeStats struct {
+	Requests atomic.Int64 // requests eligible for hedging
+	Fired    atomic.Int64 // hedges sent because the first attempt was slow or failed
+	Won      atomic.Int64 // hedges that answered before the first attempt
+}
+
+// Keeps a window of recent response latencies to derive percentiles from
+type latencyTracker struct {
+	mux      sync.Mutex
+	samples  [latencySamples]time.Duration
+	count    int
+	sorted   []time.Duration
+	sortedAt int // count at the time sorted was built
+}
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.samples[t.count%latencySamples] = d
+	t.count++
+}
+
+// Returns the latency percentile, false while there are too few samples.
+// The sorted copy is only rebuilt every few samples to keep this cheap.
+func (t *latencyTracker) percentile(p float64) (time.Duration, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.count < minLatencySamples {
+		return 0, false
+	}
+	if t.sorted == nil || t.count-t.sortedAt >= latencySamples/16 {
+		t.sorted = append(t.sorted[:0], t.samples[:min(t.count, latencySamples)]...)
+		slices.Sort(t.sorted)
+		t.sortedAt = t.count
+	}
+	i := int(float64(len(t.sorted)-1) * p / 100)
+	return t.sorted[min(max(i, 0), len(t.sorted)-1)], true
+}
+
+// Response writer of one hedged attempt. The first attempt to write a response
+// claims the client connection, everything the other attempt writes is dropped.
+type hedgeWriter struct {
+	w      http.ResponseWriter
+	id     int32
+	winner *atomic.Int32
+	onWin  func()
+	header http.Header
+}
+
+// Tries to claim the client connection, returns whether this attempt owns it
+func (hw *hedgeWriter) claim() bool {
+	if hw.winner.CompareAndSwap(0, hw.id) {
+		hw.onWin()
+		return true
+	}
+	return hw.winner.Load() == hw.id
+}
+
+func (hw *hedgeWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *hedgeWriter) WriteHeader(status int) {
+	if !hw.claim() {
+		return
+	}
+	for key, values := range hw.header {
+		hw.w.Header()[key] = values
+	}
+	hw.w.WriteHeader(status)
+}
+
+func (hw *hedgeWriter) Write(b []byte) (int, error) {
+	if hw.winner.Load() == 0 {
+		hw.WriteHeader(http.StatusOK)
+	}
+	if hw.winner.Load() != hw.id {
+		return len(b), nil
+	}
+	return hw.w.Write(b)
+}
+
+func (hw *hedgeWriter) Flush() {
+	if hw.winner.Load() == hw.id {
+		http.NewResponseController(hw.w).Flush()
+	}
+}
+
+// Result of a hedged attempt running in its own goroutine
+type hedgeResult struct {
+	id      int32
+	att     *attempt
+	aborted bool // the attempt panicked with http.ErrAbortHandler
+}
+
+// Sends the request to a worker node and, if it has not answered within the
+// configured latency percentile, a second copy to another worker. The first
+// response wins and the slower attempt is cancelled.
+func (lb *LoadBalancer) hedge(w http.ResponseWriter, r *http.Request) {
+	lb.Hedges.Requests.Add(1)
+
+	var winner atomic.Int32
+	cancels := make(map[int32]context.CancelFunc)
+	var cancelMux sync.Mutex
+	results := make(chan hedgeResult, 2)
+	tried := make(map[*Worker]bool)
+
+	launch := func(worker *Worker, id int32) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancelMux.Lock()
+		cancels[id] = cancel
+		cancelMux.Unlock()
+
+		hw := &hedgeWriter{w: w, id: id, winner: &winner, header: make(http.Header)}
+		hw.onWin = func() {
+			if id > 1 {
+				lb.Hedges.Won.Add(1)
+			}
+			// Cancel the slower attempt
+			cancelMux.Lock()
+			defer cancelMux.Unlock()
+			for other, cancel := range cancels {
+				if other != id {
+					cancel()
+				}
+			}
+		}
+
+		go func() {
+			result := hedgeResult{id: id}
+			defer func() {
+				cancel()
+				if rec := recover(); rec != nil {
+					if rec != http.ErrAbortHandler {
+						panic(rec)
+					}
+					result.aborted = true
+				}
+				results <- result
+			}()
+			result.att = lb.try(worker, hw, r.WithContext(ctx), nil, false)
+		}()
+	}
+
+	worker := lb.nextWorker(r, nil)
+	if worker == nil {
+		lb.Logger.Println("No available workers")
+		http.Error(w, "No available workers", http.StatusServiceUnavailable)
+		return
+	}
+	tried[worker] = true
+	launch(worker, 1)
+	running := 1
+
+	// Without enough latency samples the hedge is only sent when the first attempt fails
+	var timeout <-chan time.Time
+	if delay, ok := lb.latencies.percentile(lb.Hedging.Percentile); ok {
+		timer := time.NewTimer(max(delay, lb.Hedging.MinDelay))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	sendHedge := func() {
+		if winner.Load() != 0 || len(tried) > 1 {
+			return
+		}
+		// The hedge is picked like a retry, so it leaves the strategy's cursor
+		// to the first attempts and the distribution of the pool stays even
+		if worker := lb.nextWorker(r, tried); worker != nil {
+			tried[worker] = true
+			lb.Hedges.Fired.Add(1)
+			lb.Logger.Printf("Hedging request %s on %s", r.URL.Path, worker.URL)
+			launch(worker, 2)
+			running++
+		}
+	}
+
+	var last *attempt
+	aborted := false
+	for running > 0 {
+		select {
+		case <-timeout:
+			timeout = nil
+			sendHedge()
+		case result := <-results:
+			running--
+			if result.aborted {
+				// Only the attempt writing to the client can abort the response
+				aborted = aborted || winner.Load() == result.id
+				continue
+			}
+			last = result.att
+			// A failed first attempt is hedged right away instead of waiting
+			if last.heldBack && winner.Load() == 0 {
+				timeout = nil
+				sendHedge()
+			}
+		}
+	}
+
+	if aborted {
+		panic(http.ErrAbortHandler)
+	}
+	if winner.Load() == 0 && last != nil {
+		last.writeError(w)
+	}
+}
@@ -0,0 +1,136 @@
+package lb
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHedgePicksKeepDistribution(t *testing.T) {
+	tests := []struct {
+		strategy string
+		workers  int
+		requests int
+	}{
+		{RoundRobin, 3, 30},
+		{RoundRobin, 4, 40},
+		{LeastConnections, 3, 30},
+		{WeightedRoundRobin, 3, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			strategy, err := NewStrategy(tt.strategy, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+			weights := make([]int, tt.workers)
+			for i := range weights {
+				weights[i] = 1
+			}
+			pool.Workers = testWorkers(weights...)
+
+			// Every request is hedged on a second worker
+			first := make(map[*Worker]int)
+			for i := 0; i < tt.requests; i++ {
+				worker := pool.nextWorker(nil, nil)
+				hedge := pool.nextWorker(nil, map[*Worker]bool{worker: true})
+				if hedge == nil || hedge == worker {
+					t.Fatalf("request %d: hedge picked %v", i, hedge)
+				}
+				first[worker]++
+				worker.Done()
+				hedge.Done()
+			}
+
+			want := tt.requests / tt.workers
+			for i, worker := range pool.Workers {
+				if first[worker] != want {
+					t.Errorf("worker %d got %d first attempts, want %d", i+1, first[worker], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHedge(t *testing.T) {
+	const hedgeDelay = 30 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		delays    [2]time.Duration // response time of the first and the hedged worker
+		want      string           // worker whose response reaches the client
+		fired     int64
+		won       int64
+		cancelled int // worker whose attempt is cancelled, -1 for none
+	}{
+		{"first answers in time", [2]time.Duration{0, 0}, "first", 0, 0, -1},
+		{"hedge wins", [2]time.Duration{5 * time.Second, 0}, "hedge", 1, 1, 0},
+		{"first wins after the hedge", [2]time.Duration{4 * hedgeDelay, 5 * time.Second}, "first", 1, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, _ := NewStrategy(RoundRobin, "")
+			pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+			pool.Hedging = HedgeConfig{Paths: []string{"/"}, Percentile: 95, MinDelay: time.Millisecond}
+			for i := 0; i < minLatencySamples; i++ {
+				pool.latencies.observe(hedgeDelay)
+			}
+
+			cancelled := make(chan int, 2)
+			for i, name := range []string{"first", "hedge"} {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					select {
+					case <-time.After(tt.delays[i]):
+						io.WriteString(w, name)
+					case <-r.Context().Done():
+						cancelled <- i
+					}
+				}))
+				defer server.Close()
+				if err := pool.AddWorker(server.Listener.Addr().String()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			workers := pool.WorkerList()
+
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			pool.Forward(rec, httptest.NewRequest(http.MethodGet, "/data", nil))
+			elapsed := time.Since(start)
+
+			if rec.Body.String() != tt.want {
+				t.Errorf("client got %q, want %q", rec.Body.String(), tt.want)
+			}
+			if tt.fired > 0 && elapsed < hedgeDelay {
+				t.Errorf("answered after %s, before the hedge delay of %s", elapsed, hedgeDelay)
+			}
+			if elapsed > time.Second {
+				t.Errorf("answered after %s, the slower attempt was waited for", elapsed)
+			}
+			if fired, won := pool.Hedges.Fired.Load(), pool.Hedges.Won.Load(); fired != tt.fired || won != tt.won || pool.Hedges.Requests.Load() != 1 {
+				t.Errorf("requests %d, fired %d, won %d, want 1, %d, %d", pool.Hedges.Requests.Load(), fired, won, tt.fired, tt.won)
+			}
+
+			if tt.cancelled >= 0 {
+				select {
+				case i := <-cancelled:
+					if i != tt.cancelled {
+						t.Errorf("attempt on worker %d cancelled, want %d", i, tt.cancelled)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatal("the slower attempt was not cancelled")
+				}
+				// The cancelled attempt ran for as long as the winner, that is not its latency
+				if latency := workers[tt.cancelled].Latency(); latency != 0 {
+					t.Errorf("cancelled attempt recorded a latency of %s", latency)
+				}
+			}
+		})
+	}
+}
@@ -1,83 +1,146 @@
 package lb
 
 import (
+	"GoBalance/loadbalancer/lib/config"
 	"fmt"
-	"io"
 	"log"
-	"net/http/httputil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// The default pool, backed by available_nodes.txt and standby_nodes.txt
 var LB *LoadBalancer
 
+// LoadBalancer manages one named pool of worker nodes
 type LoadBalancer struct {
+	Name          string
 	Workers       []*Worker
-	CurrentWorker int
+	Strategy      Strategy
+	HealthChecker *HealthChecker
+	Outliers      *OutlierDetector
+	Breakers      BreakerConfig
+	Retries       RetryConfig
+	Hedging       HedgeConfig
+	Hedges        HedgeStats
+	Upgrades      UpgradeConfig
+	Sticky        *StickySessions   // nil when sticky sessions are disabled
+	Transport     http.RoundTripper // connections to the worker nodes, nil for the default transport
+	scheme        string            // scheme of worker addresses without one
+	protocol      string            // protocol spoken to the worker nodes
+	port          int               // port of worker addresses without one, 8080 if unset
+	upgrades      *http.Transport   // HTTP/1.1 transport of the upgrade handshakes
+	Scaling       ScalingConfig
+	DrainTimeout  time.Duration // longest wait for a removed worker to finish its requests, zero waits for all of them
+	stopping      atomic.Bool   // set once the load balancer shuts down
+	AvailableFile string
+	StandbyFile   string
+	AllFile       string
+	budget        retryBudget
+	latencies     latencyTracker
 	mux           sync.Mutex
 	Logger        *log.Logger
 }
 
-func NewLoadBalancer(logger *log.Logger) *LoadBalancer {
-	return &LoadBalancer{Logger: logger}
+func NewLoadBalancer(logger *log.Logger, strategy Strategy) *LoadBalancer {
+	return &LoadBalancer{Logger: logger, Strategy: strategy}
 }
 
-// Init initializes the load balancer
-func Init() error {
+// Init initializes one load balancer pool per configured pool
+func Init(cfg *config.Config) error {
 	err := godotenv.Load("./.env")
 	if err != nil {
 		log.Println("Error loading environment\nContinuing..")
 	}
-	writer := io.Writer(os.Stdout)
-	logger := log.New(writer, "LOADBALANCER : ", log.Ldate|log.Ltime|log.Lshortfile)
-	LB = NewLoadBalancer(logger)
-	nodesFile := filepath.Join("./", "available_nodes.txt")
+
+	pools := make(map[string]*LoadBalancer)
+	for _, poolConfig := range cfg.Pools {
+		pool, err := NewPool(poolConfig)
+		if err != nil {
+			return fmt.Errorf("error initializing pool %s: %v", poolConfig.Name, err)
+		}
+		pools[pool.Name] = pool
+	}
+
+	// Start probing the worker nodes in the background
+	for _, pool := range pools {
+		pool.HealthChecker.Start()
+	}
+
+	poolsMux.Lock()
+	Pools = pools
+	LB = pools[config.DefaultPool]
+	poolsMux.Unlock()
+
+	LB.Logger.Printf("LoadBalancer initialized successfully with %d pool(s)", len(pools))
+	return nil
+}
+
+// Function to read the node file of a pool and add its worker nodes
+func (lb *LoadBalancer) loadNodes() error {
+	nodesFile := filepath.Join("./", lb.AvailableFile)
 	nodes_raw, err := os.ReadFile(nodesFile)
 	if err != nil {
-		LB.Logger.Fatal("Error reading available_nodes file : ", err)
+		lb.Logger.Printf("Error reading %s file : %v", lb.AvailableFile, err)
 		return err
 	}
 
 	lines := strings.Split(string(nodes_raw), "\n")
 	for _, line := range lines {
 		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine != "" && isValidIPv4(trimmedLine) {
-			err = LB.AddWorker(trimmedLine)
+		if trimmedLine == "" {
+			continue
+		}
+		node, err := ParseNode(trimmedLine)
+		if err != nil {
+			lb.Logger.Println("Error parsing worker node entry: ", err)
+			continue
+		}
+		if isValidIPv4(node.Address) {
+			err = lb.AddWorker(trimmedLine)
 			if err != nil {
-				LB.Logger.Println("Error adding worker node to LB pool: ", err)
+				lb.Logger.Println("Error adding worker node to LB pool: ", err)
 			}
 		}
 	}
-	LB.Logger.Println("LoadBalancer initialized successfully")
 	return nil
 }
 
-// Method to add a worker node to the pool
+// Method to add a worker node to the pool.
+// workerURL is a line of the node files, optionally carrying a weight.
 func (lb *LoadBalancer) AddWorker(workerURL string) error {
 	if workerURL == "" || strings.HasSuffix(workerURL, "\n") || strings.HasSuffix(workerURL, "\r\n") {
 		return nil
 	}
-	parsedURL, err := parseWorkerURL(workerURL)
+	node, err := ParseNode(workerURL)
+	if err != nil {
+		return err
+	}
+	parsedURL, err := lb.WorkerURL(node.Address)
 	if err != nil {
-		return fmt.Errorf("invalid worker URL %s: %v", workerURL, err)
+		return fmt.Errorf("invalid worker URL %s: %v", node.Address, err)
 	}
 
-	worker := &Worker{
-		URL:          parsedURL,
-		ReverseProxy: httputil.NewSingleHostReverseProxy(parsedURL),
+	worker := NewWorker(parsedURL, node.Weight)
+	worker.ReverseProxy.Transport = lb.Transport
+	if lb.Breakers.Window > 0 {
+		worker.Breaker = NewCircuitBreaker(parsedURL.String(), lb.Breakers, lb.Logger)
 	}
+	lb.watch(worker)
 
 	lb.mux.Lock()
 	lb.Workers = append(lb.Workers, worker)
 	lb.mux.Unlock()
 
-	lb.Logger.Printf("Added worker: %s\n", parsedURL)
+	lb.Logger.Printf("Added worker: %s (weight: %d)\n", parsedURL, worker.Weight)
 	return nil
 }
 
@@ -86,9 +149,13 @@ func (lb *LoadBalancer) RemoveWorker(workerURL string) error {
 	if workerURL == "" || strings.HasSuffix(workerURL, "\n") || strings.HasSuffix(workerURL, "\r\n") {
 		return nil
 	}
-	parsedURL, err := parseWorkerURL(workerURL)
+	node, err := ParseNode(workerURL)
 	if err != nil {
-		return fmt.Errorf("invalid worker URL %s: %v", workerURL, err)
+		return err
+	}
+	parsedURL, err := lb.WorkerURL(node.Address)
+	if err != nil {
+		return fmt.Errorf("invalid worker URL %s: %v", node.Address, err)
 	}
 
 	lb.mux.Lock()
@@ -96,15 +163,13 @@ func (lb *LoadBalancer) RemoveWorker(workerURL string) error {
 
 	for i, worker := range lb.Workers {
 		if worker.URL.String() == parsedURL.String() {
-			// Remove the worker
+			// Remove the worker and close its upgraded and TCP connections gracefully
 			lb.Workers = append(lb.Workers[:i], lb.Workers[i+1:]...)
-
-			// Adjust CurrentWorker if necessary
-			if lb.CurrentWorker >= len(lb.Workers) {
-				lb.CurrentWorker = 0
-			}
-
 			lb.Logger.Printf("Removed worker: %s\n", parsedURL)
+			if tunnels := worker.Tunnels(); tunnels > 0 {
+				lb.Logger.Printf("Closing %d relayed connection(s) to %s", tunnels, parsedURL)
+				go worker.tunnels.closeAll()
+			}
 			return nil
 		}
 	}
@@ -112,33 +177,114 @@ func (lb *LoadBalancer) RemoveWorker(workerURL string) error {
 	return fmt.Errorf("worker not found: %s", parsedURL)
 }
 
-// Method to determine the next worker node in the pool
-func (lb *LoadBalancer) NextWorker() *Worker {
+// Method to change the weight of a worker node in the pool. Strategies that
+// derive their state from the weights start over with the new ones.
+func (lb *LoadBalancer) SetWeight(worker *Worker, weight int) {
 	lb.mux.Lock()
 	defer lb.mux.Unlock()
+	worker.Weight = weight
+	if strategy, ok := lb.Strategy.(weightedStrategy); ok {
+		strategy.reset()
+	}
+}
 
-	workerCount := len(lb.Workers)
-	if workerCount == 0 {
-		lb.Logger.Println("No workers available")
-		return nil
+// Current weight of a worker node in the pool
+func (lb *LoadBalancer) WorkerWeight(worker *Worker) int {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	return worker.Weight
+}
+
+// Returns a copy of the worker nodes currently in the pool
+func (lb *LoadBalancer) WorkerList() []*Worker {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	return append([]*Worker(nil), lb.Workers...)
+}
+
+// Number of worker nodes in the pool that are not being drained
+func (lb *LoadBalancer) ActiveWorkers() int {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+
+	active := 0
+	for _, worker := range lb.Workers {
+		if !worker.draining.Load() {
+			active++
+		}
 	}
+	return active
+}
 
-	// Ensure CurrentWorker is within bounds
-	if lb.CurrentWorker >= workerCount {
-		lb.CurrentWorker = 0
+// Determines the next worker node in the pool for the given request, leaving
+// out the excluded workers. The caller must call Done on the returned worker
+// once the request has finished.
+func (lb *LoadBalancer) nextWorker(r *http.Request, exclude map[*Worker]bool) *Worker {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+
+	// Only healthy workers that have not been ejected, disabled or put to
+	// draining and whose circuit lets traffic through take part in the selection.
+	// Workers already tried stay in the candidates so the strategy keeps its
+	// state keyed to the same set of workers, it skips them itself.
+	candidates := make([]*Worker, 0, len(lb.Workers))
+	untried := 0
+	for _, worker := range lb.Workers {
+		if worker.Healthy() && !worker.Ejected() && worker.Accepting() && (worker.Breaker == nil || worker.Breaker.Ready()) {
+			candidates = append(candidates, worker)
+			if !exclude[worker] {
+				untried++
+			}
+		}
+	}
+	if untried == 0 {
+		lb.Logger.Println("No healthy workers available")
+		return nil
 	}
 
-	worker := lb.Workers[lb.CurrentWorker]
-	lb.CurrentWorker = (lb.CurrentWorker + 1) % workerCount
+	// Clients pinned to a worker go back to it while it takes traffic,
+	// everyone else is left to the configured strategy
+	var worker *Worker
+	if lb.Sticky != nil {
+		worker = lb.Sticky.worker(r, candidates)
+		if exclude[worker] {
+			worker = nil
+		}
+	}
+	if worker == nil {
+		worker = lb.Strategy.Next(candidates, r, exclude)
+	}
+	if worker == nil {
+		lb.Logger.Println("No workers available")
+		return nil
+	}
+	worker.inFlight.Add(1)
+	if worker.Breaker != nil {
+		worker.Breaker.Dispatched()
+	}
 
-	lb.Logger.Printf("Selected worker %d: %s\n", lb.CurrentWorker, worker.URL)
+	lb.Logger.Printf("Selected worker: %s (in-flight: %d)\n", worker.URL, worker.InFlight())
 	return worker
 }
 
+// Method to parse and normalize worker URLs, addresses without a scheme use
+// https when the pool talks TLS to its workers
+func (lb *LoadBalancer) WorkerURL(address string) (*url.URL, error) {
+	scheme := lb.scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	port := lb.port
+	if port == 0 {
+		port = 8080
+	}
+	return parseWorkerURL(address, scheme, port)
+}
+
 // Function to parse and normalize worker URLs
-func parseWorkerURL(workerURL string) (*url.URL, error) {
+func parseWorkerURL(workerURL, scheme string, port int) (*url.URL, error) {
 	if !strings.HasPrefix(workerURL, "http://") && !strings.HasPrefix(workerURL, "https://") {
-		workerURL = "http://" + workerURL
+		workerURL = scheme + "://" + workerURL
 	}
 
 	parsedURL, err := url.Parse(workerURL)
@@ -147,7 +293,7 @@ func parseWorkerURL(workerURL string) (*url.URL, error) {
 	}
 
 	if parsedURL.Port() == "" {
-		parsedURL.Host = fmt.Sprintf("%s:8080", parsedURL.Host)
+		parsedURL.Host = fmt.Sprintf("%s:%d", parsedURL.Host, port)
 	}
 
 	return parsedURL, nil
@@ -0,0 +1,234 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/file"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Method to find the worker node with the given address or node file line,
+// nil if it is not in the pool
+func (lb *LoadBalancer) FindWorker(address string) *Worker {
+	node, err := ParseNode(address)
+	if err != nil {
+		return nil
+	}
+	parsedURL, err := lb.WorkerURL(node.Address)
+	if err != nil {
+		return nil
+	}
+	for _, worker := range lb.WorkerList() {
+		if worker.URL.String() == parsedURL.String() {
+			return worker
+		}
+	}
+	return nil
+}
+
+// Method to add a worker node at runtime. line is a line of the node files,
+// it is listed in the available nodes file and taken off the standby nodes file.
+func (lb *LoadBalancer) AddNode(line string) error {
+	node, err := ParseNode(line)
+	if err != nil {
+		return err
+	}
+	if !isValidIPv4(node.Address) {
+		return fmt.Errorf("invalid worker address: %s", node.Address)
+	}
+
+	file.NodesMux.Lock()
+	defer file.NodesMux.Unlock()
+
+	if lb.FindWorker(node.Address) != nil {
+		return fmt.Errorf("worker %s is already in the pool", node.Address)
+	}
+	if err := lb.AddWorker(node.String()); err != nil {
+		return err
+	}
+
+	if _, err := file.RemoveLine(lb.StandbyFile, matchNode(node.Address)); err != nil && !os.IsNotExist(err) {
+		lb.Logger.Printf("Error updating %s: %v", lb.StandbyFile, err)
+	}
+	if err := file.AppendToFile(lb.AvailableFile, node.String()); err != nil {
+		return fmt.Errorf("worker added but %s could not be updated: %v", lb.AvailableFile, err)
+	}
+	// The all nodes file is optional, it only feeds the stats
+	if nodes, err := file.ReadIPAddresses(lb.AllFile); err == nil && !containsNode(nodes, node.Address) {
+		if err := file.AppendToFile(lb.AllFile, node.String()); err != nil {
+			lb.Logger.Printf("Error updating %s: %v", lb.AllFile, err)
+		}
+	}
+	return nil
+}
+
+// Method to take a worker node out of the pool at runtime and move it from the
+// available nodes file to the standby nodes file
+func (lb *LoadBalancer) RemoveNode(address string) error {
+	node, err := ParseNode(address)
+	if err != nil {
+		return err
+	}
+
+	file.NodesMux.Lock()
+	defer file.NodesMux.Unlock()
+
+	worker := lb.FindWorker(node.Address)
+	if worker == nil {
+		return fmt.Errorf("worker %s is not in the pool", node.Address)
+	}
+	if err := lb.RemoveWorker(node.Address); err != nil {
+		return err
+	}
+
+	// Keep the settings the node is listed with, such as its weight
+	line, err := file.RemoveLine(lb.AvailableFile, matchNode(node.Address))
+	if err != nil {
+		return fmt.Errorf("worker removed but %s could not be updated: %v", lb.AvailableFile, err)
+	}
+	if line == "" {
+		line = Node{Address: node.Address, Weight: worker.Weight}.String()
+	}
+	if err := file.AppendToFile(lb.StandbyFile, line); err != nil {
+		return fmt.Errorf("worker removed but %s could not be updated: %v", lb.StandbyFile, err)
+	}
+	return nil
+}
+
+// Method to stop giving the worker node new traffic and remove it from the
+// pool once it has been drained, moving it to the standby nodes file
+func (lb *LoadBalancer) DrainNode(address string) error {
+	worker := lb.FindWorker(address)
+	if worker == nil {
+		return fmt.Errorf("worker %s is not in the pool", address)
+	}
+	if !worker.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	go func() {
+		if !lb.drain(worker) {
+			return
+		}
+		if err := lb.RemoveNode(address); err != nil {
+			lb.Logger.Printf("Error removing drained worker %s: %v", worker.URL, err)
+			return
+		}
+		lb.Logger.Printf("Worker %s drained and moved to standby", worker.URL)
+	}()
+	return nil
+}
+
+// Method to start draining a worker node, it gets no new traffic from then on.
+// Returns a function that blocks until the worker is drained and removed from
+// the pool, the node files are left to the caller.
+func (lb *LoadBalancer) StartDrain(address string) (func() error, error) {
+	worker := lb.FindWorker(address)
+	if worker == nil {
+		return nil, fmt.Errorf("worker %s is not in the pool", address)
+	}
+	if !worker.draining.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("worker %s is already draining", worker.URL)
+	}
+	return func() error {
+		if !lb.drain(worker) {
+			return fmt.Errorf("drain of worker %s was canceled", worker.URL)
+		}
+		return lb.RemoveWorker(address)
+	}, nil
+}
+
+// Waits until the draining worker node has finished its outstanding requests
+// and connections or the drain timeout has passed. Whatever is still open
+// after the timeout is closed when the worker is removed. Returns false if the
+// drain was canceled by enabling the worker again.
+func (lb *LoadBalancer) drain(worker *Worker) bool {
+	started := time.Now()
+	worker.drainStarted.Store(started.UnixNano())
+	defer worker.drainStarted.Store(0)
+	lb.Logger.Printf("Draining worker %s (in-flight: %d)", worker.URL, worker.InFlight())
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if !worker.draining.Load() {
+			lb.Logger.Printf("Drain of worker %s canceled", worker.URL)
+			return false
+		}
+		if worker.InFlight() == 0 {
+			lb.Logger.Printf("Worker %s drained after %s", worker.URL, time.Since(started).Round(time.Millisecond))
+			return true
+		}
+		if lb.DrainTimeout > 0 && time.Since(started) >= lb.DrainTimeout {
+			lb.Logger.Printf("Drain timeout passed with %d request(s) and connection(s) still open on %s", worker.InFlight(), worker.URL)
+			return true
+		}
+		<-ticker.C
+	}
+}
+
+// Progress of a worker node being drained
+type DrainStatus struct {
+	InFlight  int64  `json:"in_flight"`           // requests and connections still open
+	Elapsed   string `json:"elapsed"`             // time since the drain started
+	Remaining string `json:"remaining,omitempty"` // time left until the drain timeout
+}
+
+// Method to report the drain progress of a worker node, false if it is not being drained
+func (lb *LoadBalancer) DrainProgress(worker *Worker) (DrainStatus, bool) {
+	started := worker.drainStarted.Load()
+	if started == 0 {
+		return DrainStatus{}, false
+	}
+	elapsed := time.Since(time.Unix(0, started))
+	status := DrainStatus{
+		InFlight: worker.InFlight(),
+		Elapsed:  elapsed.Round(time.Millisecond).String(),
+	}
+	if lb.DrainTimeout > 0 {
+		status.Remaining = max(lb.DrainTimeout-elapsed, 0).Round(time.Millisecond).String()
+	}
+	return status, true
+}
+
+// Method to put a worker node back in rotation, which also cancels a drain
+func (lb *LoadBalancer) EnableNode(address string) error {
+	worker := lb.FindWorker(address)
+	if worker == nil {
+		return fmt.Errorf("worker %s is not in the pool", address)
+	}
+	worker.disabled.Store(false)
+	worker.draining.Store(false)
+	lb.Logger.Printf("Worker %s enabled", worker.URL)
+	return nil
+}
+
+// Method to take a worker node out of rotation while keeping it in the pool
+func (lb *LoadBalancer) DisableNode(address string) error {
+	worker := lb.FindWorker(address)
+	if worker == nil {
+		return fmt.Errorf("worker %s is not in the pool", address)
+	}
+	worker.disabled.Store(true)
+	lb.Logger.Printf("Worker %s disabled", worker.URL)
+	return nil
+}
+
+// Returns a function matching the node file lines of the given address
+func matchNode(address string) func(string) bool {
+	return func(line string) bool {
+		node, err := ParseNode(line)
+		return err == nil && node.Address == address
+	}
+}
+
+// Reports whether the node file lines list the given address
+func containsNode(lines []string, address string) bool {
+	match := matchNode(address)
+	for _, line := range lines {
+		if match(line) {
+			return true
+		}
+	}
+	return false
+}
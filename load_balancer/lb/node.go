@@ -0,0 +1,51 @@
+package lb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Node is a single line of the node files: the address of a worker node
+// followed by optional key=value settings, e.g. "10.0.0.5 weight=3"
+type Node struct {
+	Address string
+	Weight  int
+}
+
+// Function to parse a line of the node files
+func ParseNode(line string) (Node, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Node{}, fmt.Errorf("empty node entry")
+	}
+
+	node := Node{Address: fields[0], Weight: 1}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return Node{}, fmt.Errorf("invalid option %q for node %s", field, node.Address)
+		}
+
+		switch key {
+		case "weight":
+			weight, err := strconv.Atoi(value)
+			if err != nil || weight < 1 {
+				return Node{}, fmt.Errorf("invalid weight %q for node %s", value, node.Address)
+			}
+			node.Weight = weight
+		default:
+			return Node{}, fmt.Errorf("unknown option %q for node %s", key, node.Address)
+		}
+	}
+
+	return node, nil
+}
+
+// String formats the node the way it is written to the node files
+func (n Node) String() string {
+	if n.Weight > 1 {
+		return fmt.Sprintf("%s weight=%d", n.Address, n.Weight)
+	}
+	return n.Address
+}
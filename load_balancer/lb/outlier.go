@@ -0,0 +1,113 @@
+package lb
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Settings of the passive outlier detection
+type OutlierConfig struct {
+	ConsecutiveErrors  int           // 5xx responses or transport errors in a row before ejection
+	BaseEjectionTime   time.Duration // doubled on every repeated ejection of the same worker
+	MaxEjectionTime    time.Duration
+	MaxEjectionPercent int // share of the pool that may be ejected at the same time
+}
+
+// Function to read the outlier detection settings from the environment
+func LoadOutlierConfig(logger *log.Logger) OutlierConfig {
+	return OutlierConfig{
+		ConsecutiveErrors:  max(envInt(logger, "OUTLIER_CONSECUTIVE_ERRORS", 5), 1),
+		BaseEjectionTime:   EnvDuration(logger, "OUTLIER_BASE_EJECTION_TIME", 30*time.Second),
+		MaxEjectionTime:    EnvDuration(logger, "OUTLIER_MAX_EJECTION_TIME", 5*time.Minute),
+		MaxEjectionPercent: envInt(logger, "OUTLIER_MAX_EJECTION_PERCENT", 50),
+	}
+}
+
+// Outlier state of a worker node, guarded by its own lock
+type outlierState struct {
+	mux               sync.Mutex
+	consecutiveErrors int
+	ejections         int // number of ejections in a row, drives the ejection time
+	ejectedUntil      time.Time
+}
+
+// Reports whether the worker node is currently ejected from rotation
+func (w *Worker) Ejected() bool {
+	w.outlier.mux.Lock()
+	defer w.outlier.mux.Unlock()
+	return time.Now().Before(w.outlier.ejectedUntil)
+}
+
+// OutlierDetector watches the responses proxied to each worker node and
+// temporarily ejects workers that keep failing real traffic.
+type OutlierDetector struct {
+	lb     *LoadBalancer
+	config OutlierConfig
+}
+
+func NewOutlierDetector(lb *LoadBalancer, config OutlierConfig) *OutlierDetector {
+	return &OutlierDetector{lb: lb, config: config}
+}
+
+// Record counts the outcome of a proxied request and ejects the worker node
+// once it has failed too many requests in a row
+func (od *OutlierDetector) Record(worker *Worker, success bool) {
+	state := &worker.outlier
+	state.mux.Lock()
+	if success {
+		state.consecutiveErrors = 0
+		// Forgive earlier ejections once the worker has behaved for a while
+		if state.ejections > 0 && time.Since(state.ejectedUntil) > od.config.MaxEjectionTime {
+			state.ejections = 0
+		}
+		state.mux.Unlock()
+		return
+	}
+	state.consecutiveErrors++
+	tripped := state.consecutiveErrors >= od.config.ConsecutiveErrors && !time.Now().Before(state.ejectedUntil)
+	state.mux.Unlock()
+
+	if tripped && od.canEject() {
+		od.eject(worker)
+	}
+}
+
+// Checks that ejecting one more worker keeps enough of the pool in rotation
+func (od *OutlierDetector) canEject() bool {
+	od.lb.mux.Lock()
+	defer od.lb.mux.Unlock()
+
+	ejected := 0
+	for _, worker := range od.lb.Workers {
+		if worker.Ejected() {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(od.lb.Workers)*od.config.MaxEjectionPercent {
+		od.lb.Logger.Printf("Not ejecting another worker, %d of %d workers are already ejected", ejected, len(od.lb.Workers))
+		return false
+	}
+	return true
+}
+
+// Takes the worker node out of rotation for an exponentially growing period
+func (od *OutlierDetector) eject(worker *Worker) {
+	state := &worker.outlier
+	state.mux.Lock()
+	defer state.mux.Unlock()
+
+	duration := od.config.BaseEjectionTime << state.ejections
+	if duration > od.config.MaxEjectionTime || duration <= 0 {
+		duration = od.config.MaxEjectionTime
+	} else {
+		state.ejections++
+	}
+	state.consecutiveErrors = 0
+	state.ejectedUntil = time.Now().Add(duration)
+
+	od.lb.Logger.Printf("Ejected worker %s for %s after %d consecutive errors", worker.URL, duration, od.config.ConsecutiveErrors)
+	time.AfterFunc(duration, func() {
+		od.lb.Logger.Printf("Worker %s is back in rotation after ejection", worker.URL)
+	})
+}
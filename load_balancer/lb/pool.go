@@ -0,0 +1,152 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Pools holds every configured pool by name, including the default pool
+	Pools    map[string]*LoadBalancer
+	poolsMux sync.RWMutex
+)
+
+// Returns the pool with the given name, nil if there is none
+func GetPool(name string) *LoadBalancer {
+	poolsMux.RLock()
+	defer poolsMux.RUnlock()
+	return Pools[name]
+}
+
+// Returns all pools ordered by name
+func AllPools() []*LoadBalancer {
+	poolsMux.RLock()
+	defer poolsMux.RUnlock()
+
+	pools := make([]*LoadBalancer, 0, len(Pools))
+	for _, pool := range Pools {
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
+
+// Limits used by the scaling middleware of a pool
+type ScalingConfig struct {
+	MaxConcurrent int64 // concurrent requests accepted before answering 429
+	MinWorkers    int64
+	MaxWorkers    int64
+}
+
+// Function to read the scaling limits from the environment
+func LoadScalingConfig(logger *log.Logger) ScalingConfig {
+	scaling := ScalingConfig{
+		MaxConcurrent: int64(envInt(logger, "POOL", 20)),
+		MinWorkers:    int64(envInt(logger, "WORKER", 2)),
+	}
+	scaling.MaxWorkers = int64(envInt(logger, "MAX_WORKER", int(scaling.MinWorkers)))
+	return scaling
+}
+
+// Function to create a pool from its configuration and load its worker nodes.
+// Settings the pool leaves empty are taken from the environment.
+func NewPool(cfg config.Pool) (*LoadBalancer, error) {
+	prefix := "LOADBALANCER : "
+	if cfg.Name != config.DefaultPool {
+		prefix = "LOADBALANCER [" + cfg.Name + "] : "
+	}
+	writer := io.Writer(os.Stdout)
+	logger := log.New(writer, prefix, log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Select the balancing strategy, falling back to round robin
+	strategyName := cfg.Strategy
+	if strategyName == "" {
+		strategyName = envString("STRATEGY", RoundRobin)
+	}
+	hashKey := cfg.HashKey
+	if hashKey == "" {
+		hashKey = os.Getenv("HASH_KEY")
+	}
+	strategy, err := NewStrategy(strategyName, hashKey)
+	if err != nil {
+		logger.Printf("Error configuring balancing strategy: %v. Using %s.", err, RoundRobin)
+		strategyName = RoundRobin
+		strategy, _ = NewStrategy(strategyName, "")
+	}
+	logger.Printf("Balancing strategy set to: %s", strategyName)
+
+	pool := NewLoadBalancer(logger, strategy)
+	pool.Name = cfg.Name
+	pool.AvailableFile = cfg.AvailableNodes
+	pool.StandbyFile = cfg.StandbyNodes
+	pool.AllFile = cfg.AllNodes
+	pool.port = cfg.Port
+	pool.Outliers = NewOutlierDetector(pool, LoadOutlierConfig(logger))
+	pool.Breakers = LoadBreakerConfig(logger)
+	pool.Retries = LoadRetryConfig(logger)
+	pool.Hedging = LoadHedgeConfig(logger)
+
+	upstreamTLS := LoadUpstreamTLSConfig(logger).merge(cfg.UpstreamTLS)
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = envString("UPSTREAM_PROTOCOL", ProtocolAuto)
+	}
+	pool.Transport, err = newTransport(upstreamTLS, protocol)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring upstream connections: %v", err)
+	}
+	pool.scheme = upstreamTLS.scheme()
+	pool.protocol = strings.ToLower(strings.TrimSpace(protocol))
+	pool.upgrades, err = newUpgradeTransport(upstreamTLS)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring upstream connections: %v", err)
+	}
+	pool.Upgrades = LoadUpgradeConfig(logger)
+	if upstreamTLS.Enabled {
+		logger.Println("Worker nodes are reached over TLS")
+	}
+	logger.Printf("Upstream protocol set to: %s", protocol)
+
+	sticky := LoadStickyConfig(logger, cfg.Name).merge(cfg.Sticky)
+	if sticky.Cookie != "" {
+		pool.Sticky, err = NewStickySessions(logger, cfg.Name, sticky)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("Sticky sessions enabled with cookie %s", sticky.Cookie)
+	}
+
+	pool.Scaling = LoadScalingConfig(logger)
+	if cfg.MaxConcurrent > 0 {
+		pool.Scaling.MaxConcurrent = int64(cfg.MaxConcurrent)
+	}
+	if cfg.MinWorkers > 0 {
+		pool.Scaling.MinWorkers = int64(cfg.MinWorkers)
+	}
+	if cfg.MaxWorkers > 0 {
+		pool.Scaling.MaxWorkers = int64(cfg.MaxWorkers)
+	}
+	pool.Scaling.MaxWorkers = max(pool.Scaling.MaxWorkers, pool.Scaling.MinWorkers)
+	pool.DrainTimeout = EnvDuration(logger, "DRAIN_TIMEOUT", 30*time.Second)
+	if cfg.DrainTimeout > 0 {
+		pool.DrainTimeout = cfg.DrainTimeout
+	}
+	logger.Printf("Drain timeout set to: %s", pool.DrainTimeout)
+	logger.Printf("Max concurrent requests is set to: %d", pool.Scaling.MaxConcurrent)
+	logger.Printf("Pool size set to: %d-%d workers", pool.Scaling.MinWorkers, pool.Scaling.MaxWorkers)
+
+	if err := pool.loadNodes(); err != nil {
+		return nil, err
+	}
+
+	healthCheck := LoadHealthCheckConfig(logger).merge(logger, cfg.HealthCheck)
+	pool.HealthChecker = NewHealthChecker(pool, healthCheck)
+	return pool, nil
+}
@@ -0,0 +1,212 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Returned from ModifyResponse to hold back a response that will be retried
+var errRetryableStatus = errors.New("retryable upstream status")
+
+// Outcome of a single attempt at proxying a request to a worker node
+type attempt struct {
+	final    bool // the response has to reach the client, no retry follows
+	heldBack bool // the attempt failed and nothing was written to the client
+	status   int  // status of a held back response, the upstream one or the error's
+}
+
+type attemptKey struct{}
+
+// Returns the attempt a proxied request belongs to, nil outside of Forward
+func attemptFrom(ctx context.Context) *attempt {
+	att, _ := ctx.Value(attemptKey{}).(*attempt)
+	return att
+}
+
+// Writes the error of a failed attempt whose response was held back
+func (att *attempt) writeError(w http.ResponseWriter) {
+	status := att.status
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// Forward proxies the request to a worker node picked by the strategy. Failed
+// idempotent requests are retried on a different worker as long as the retry
+// policy and the retry budget allow it, GET requests on hedged routes are hedged.
+func (lb *LoadBalancer) Forward(w http.ResponseWriter, r *http.Request) {
+	lb.budget.active.Add(1)
+	defer lb.budget.active.Add(-1)
+
+	// Tell the workers whether the client connection was encrypted
+	if r.TLS != nil {
+		r.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		r.Header.Set("X-Forwarded-Proto", "http")
+	}
+
+	// Upgraded connections stay with one worker for their whole lifetime
+	if IsUpgrade(r) {
+		lb.upgrade(w, r)
+		return
+	}
+
+	// gRPC needs HTTP/2 all the way, every call is balanced on its own
+	if isGRPC(r) && !lb.speaksHTTP2() {
+		lb.Logger.Println("gRPC request received but the pool does not speak HTTP/2 to its workers, set upstream_protocol to h2 or h2c")
+		writeProxyError(w, r, "Pool does not support gRPC", http.StatusBadGateway)
+		return
+	}
+
+	// Latency-critical reads are hedged instead of retried
+	if r.Method == http.MethodGet && lb.Hedging.matches(r.URL.Path) {
+		lb.hedge(w, r)
+		return
+	}
+
+	attempts := 1
+	if isIdempotent(r.Method) {
+		attempts = lb.Retries.Attempts
+	}
+	// Only requests that may be retried are buffered, the rest keep streaming
+	var body []byte
+	if attempts > 1 {
+		var replayable bool
+		var err error
+		body, replayable, err = bufferBody(r, lb.Retries.MaxBodyBytes)
+		if err != nil {
+			writeProxyError(w, r, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		if !replayable {
+			attempts = 1
+		}
+	}
+
+	tried := make(map[*Worker]bool)
+	var last *attempt
+	for i := 1; i <= attempts; i++ {
+		if i > 1 && !lb.budget.acquireRetry(lb.Retries) {
+			lb.Logger.Println("Retry budget exhausted, not retrying request")
+			break
+		}
+
+		worker := lb.nextWorker(r, tried)
+		if worker == nil {
+			if i > 1 {
+				lb.budget.releaseRetry()
+				break
+			}
+			lb.Logger.Println("No available workers")
+			writeProxyError(w, r, "No available workers", http.StatusServiceUnavailable)
+			return
+		}
+		tried[worker] = true
+
+		last = lb.try(worker, w, r, body, i == attempts)
+		if i > 1 {
+			lb.budget.releaseRetry()
+		}
+		if !last.heldBack {
+			return
+		}
+		if i < attempts {
+			lb.Logger.Printf("Attempt %d of %d to %s failed, retrying on another worker", i, attempts, worker.URL)
+		}
+	}
+
+	// The last attempt was held back for a retry that could not happen
+	last.writeError(w)
+}
+
+// Proxies the request to the given worker node once
+func (lb *LoadBalancer) try(worker *Worker, w http.ResponseWriter, r *http.Request, body []byte, final bool) *attempt {
+	defer worker.Done()
+
+	att := &attempt{final: final}
+	ctx := context.WithValue(r.Context(), attemptKey{}, att)
+	if lb.Retries.PerTryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lb.Retries.PerTryTimeout)
+		defer cancel()
+	}
+	req := r.WithContext(ctx)
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
+	// Measure the upstream latency for latency-aware strategies
+	startTime := time.Now()
+	worker.ReverseProxy.ServeHTTP(w, req)
+	latency := time.Since(startTime)
+	// Cancelled attempts, such as the loser of a hedge, would skew the percentiles
+	// and the latency of the worker with the time the other attempt took
+	if !att.heldBack && ctx.Err() == nil {
+		worker.ObserveLatency(latency)
+		lb.latencies.observe(latency)
+	}
+
+	lb.Logger.Printf("Worker at %s served request after %dms", worker.URL, latency.Milliseconds())
+	return att
+}
+
+// Hooks into the reverse proxy of the worker node so the outcome of every
+// proxied request reaches the outlier detection and the circuit breaker
+func (lb *LoadBalancer) watch(worker *Worker) {
+	worker.ReverseProxy.ModifyResponse = func(resp *http.Response) error {
+		success := resp.StatusCode < http.StatusInternalServerError
+		lb.record(worker, success)
+
+		// Hold the response back if another worker will be tried
+		att := attemptFrom(resp.Request.Context())
+		if att != nil && !att.final && lb.Retries.StatusCodes[resp.StatusCode] {
+			att.heldBack = true
+			att.status = resp.StatusCode
+			return errRetryableStatus
+		}
+		if lb.Sticky != nil && success {
+			lb.Sticky.stick(resp, worker)
+		}
+		return nil
+	}
+	worker.ReverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		att := attemptFrom(r.Context())
+		if errors.Is(err, errRetryableStatus) {
+			return
+		}
+
+		lb.Logger.Printf("Error proxying request to %s: %v", worker.URL, err)
+		// A client that went away says nothing about the worker and is not retried
+		canceled := errors.Is(err, context.Canceled)
+		if !canceled {
+			lb.record(worker, false)
+		}
+		// An attempt that ran into the per-try timeout is told apart from a failed connection
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		if att != nil && !att.final && !canceled {
+			att.heldBack = true
+			att.status = status
+			return
+		}
+		writeProxyError(w, r, http.StatusText(status), status)
+	}
+}
+
+// Reports the outcome of a proxied request
+func (lb *LoadBalancer) record(worker *Worker, success bool) {
+	if lb.Outliers != nil {
+		lb.Outliers.Record(worker, success)
+	}
+	if worker.Breaker != nil {
+		worker.Breaker.Record(success)
+	}
+}
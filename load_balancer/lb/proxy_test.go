@@ -0,0 +1,57 @@
+package lb
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestForwardTimeoutStatus(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	refusing := httptest.NewServer(http.NotFoundHandler())
+	refusing.Close()
+
+	tests := []struct {
+		name     string
+		worker   string
+		attempts int
+		budget   int // retries always allowed, 0 leaves a held back attempt without a retry
+		want     int
+	}{
+		{"timeout", slow.Listener.Addr().String(), 1, 0, http.StatusGatewayTimeout},
+		{"timeout on every attempt", slow.Listener.Addr().String(), 2, 1, http.StatusGatewayTimeout},
+		{"timeout without a retry left", slow.Listener.Addr().String(), 2, 0, http.StatusGatewayTimeout},
+		{"connection refused", refusing.Listener.Addr().String(), 1, 0, http.StatusBadGateway},
+		{"connection refused without a retry left", refusing.Listener.Addr().String(), 2, 0, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, _ := NewStrategy(RoundRobin, "")
+			pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+			pool.Retries = RetryConfig{Attempts: tt.attempts, PerTryTimeout: 50 * time.Millisecond, MinConcurrency: tt.budget}
+			if err := pool.AddWorker(tt.worker); err != nil {
+				t.Fatal(err)
+			}
+			// The same address again gives the retry a second worker
+			if err := pool.AddWorker(tt.worker); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			pool.Forward(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"GoBalance/loadbalancer/lib/file"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reloader watches the configuration file and the node files of every pool
+// and applies their changes without a restart. The files are checked for
+// changes on an interval, Reload applies them right away, e.g. on SIGHUP.
+type Reloader struct {
+	path   string
+	apply  func(cfg *config.Config) error // applies a new configuration, an error rejects it
+	logger *log.Logger
+	stamps map[string]string // modification stamps of the files when they were last read
+	mux    sync.Mutex
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// Function to create the reloader of the configuration file at path. The
+// files as they are now count as loaded.
+func NewReloader(logger *log.Logger, path string, apply func(cfg *config.Config) error) *Reloader {
+	r := &Reloader{
+		path:   path,
+		apply:  apply,
+		logger: logger,
+		stamps: make(map[string]string),
+		stop:   make(chan struct{}),
+	}
+	r.changed(path)
+	for _, pool := range AllPools() {
+		for _, path := range pool.nodeFiles() {
+			r.changed(path)
+		}
+	}
+	return r
+}
+
+// Start checks the files for changes on the given interval until Stop is called
+func (r *Reloader) Start(interval time.Duration) {
+	if interval <= 0 {
+		r.logger.Println("Watching the configuration and node files is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.reload(false)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background checks
+func (r *Reloader) Stop() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+// Method to reload the configuration and the node files of every pool,
+// whether they changed or not
+func (r *Reloader) Reload() {
+	r.reload(true)
+}
+
+// Reloads the files that changed. A configuration that fails to load or to
+// apply is rejected and the previous one stays in use until the file changes again.
+func (r *Reloader) reload(force bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.changed(r.path) || force {
+		cfg, err := config.Load(r.path)
+		if err == nil {
+			err = r.apply(cfg)
+		}
+		if err != nil {
+			r.logger.Printf("Error reloading %s, keeping the previous configuration: %v", r.path, err)
+		} else {
+			r.logger.Printf("Configuration reloaded from %s", r.path)
+		}
+	}
+
+	for _, pool := range AllPools() {
+		changed := force
+		for _, path := range pool.nodeFiles() {
+			// Every file is checked so its stamp is recorded
+			if r.changed(path) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := pool.SyncNodes(); err != nil {
+			pool.Logger.Printf("Error reloading the worker nodes: %v", err)
+		}
+	}
+}
+
+// Reports whether the file changed since it was last seen and records its stamp
+func (r *Reloader) changed(path string) bool {
+	stamp := "missing"
+	if info, err := os.Stat(path); err == nil {
+		stamp = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+	}
+	if previous, ok := r.stamps[path]; ok && previous == stamp {
+		return false
+	}
+	r.stamps[path] = stamp
+	return true
+}
+
+// Node files of the pool
+func (lb *LoadBalancer) nodeFiles() []string {
+	return []string{lb.AvailableFile, lb.StandbyFile, lb.AllFile}
+}
+
+// Method to bring the worker nodes of the pool in line with its available
+// nodes file. Workers listed in the file are added, workers no longer listed
+// are drained and removed. Workers already being drained are left alone.
+func (lb *LoadBalancer) SyncNodes() error {
+	file.NodesMux.Lock()
+	defer file.NodesMux.Unlock()
+	if lb.Stopping() {
+		return nil
+	}
+
+	lines, err := file.ReadIPAddresses(lb.AvailableFile)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", lb.AvailableFile, err)
+	}
+
+	listed := make(map[*Worker]bool)
+	added := 0
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		node, err := ParseNode(line)
+		if err != nil {
+			lb.Logger.Println("Error parsing worker node entry: ", err)
+			continue
+		}
+		if !isValidIPv4(node.Address) {
+			continue
+		}
+		if worker := lb.FindWorker(node.Address); worker != nil {
+			listed[worker] = true
+			if lb.WorkerWeight(worker) != node.Weight && !worker.draining.Load() {
+				lb.SetWeight(worker, node.Weight)
+				lb.Logger.Printf("Weight of worker %s changed to %d", worker.URL, node.Weight)
+			}
+			continue
+		}
+		if err := lb.AddWorker(node.String()); err != nil {
+			lb.Logger.Println("Error adding worker node to LB pool: ", err)
+			continue
+		}
+		listed[lb.FindWorker(node.Address)] = true
+		added++
+	}
+
+	removed := 0
+	for _, worker := range lb.WorkerList() {
+		if listed[worker] || worker.draining.Load() {
+			continue
+		}
+		wait, err := lb.StartDrain(worker.URL.Host)
+		if err != nil {
+			lb.Logger.Printf("Error removing worker %s: %v", worker.URL, err)
+			continue
+		}
+		go func() {
+			if err := wait(); err != nil {
+				lb.Logger.Printf("Error removing worker %s: %v", worker.URL, err)
+				return
+			}
+			lb.Logger.Printf("Worker %s drained and removed", worker.URL)
+		}()
+		removed++
+	}
+
+	if added > 0 || removed > 0 {
+		lb.Logger.Printf("Worker nodes reloaded from %s: %d added, %d removed", lb.AvailableFile, added, removed)
+	}
+	return nil
+}
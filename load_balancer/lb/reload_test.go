@@ -0,0 +1,63 @@
+package lb
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncNodesWeightChange(t *testing.T) {
+	for _, name := range []string{WeightedRoundRobin, ConsistentHash} {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := NewStrategy(name, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+			pool.AvailableFile = filepath.Join(t.TempDir(), "available_nodes.txt")
+			sync := func(nodes string) {
+				t.Helper()
+				if err := os.WriteFile(pool.AvailableFile, []byte(nodes), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := pool.SyncNodes(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Share of 1000 picks the second worker gets
+			share := func() int {
+				second := pool.FindWorker("10.0.0.2")
+				picks := 0
+				for i := 0; i < 1000; i++ {
+					r := &http.Request{RemoteAddr: fmt.Sprintf("192.0.%d.%d:1000", i/250, i%250), Header: make(http.Header)}
+					worker := pool.nextWorker(r, nil)
+					if worker == second {
+						picks++
+					}
+					worker.Done()
+				}
+				return picks
+			}
+
+			sync("10.0.0.1\n10.0.0.2\n")
+			before := share()
+			worker := pool.FindWorker("10.0.0.2")
+
+			sync("10.0.0.1\n10.0.0.2 weight=4\n")
+			if pool.FindWorker("10.0.0.2") != worker {
+				t.Fatal("the worker was replaced instead of reweighted")
+			}
+			if weight := pool.WorkerWeight(worker); weight != 4 {
+				t.Fatalf("weight %d after the reload, want 4", weight)
+			}
+			// The second worker goes from about half to about 4/5 of the picks
+			if after := share(); after < 700 || after <= before {
+				t.Errorf("second worker got %d of 1000 picks after the reload, %d before", after, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package lb
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of the retry policy for failed upstream requests
+type RetryConfig struct {
+	Attempts       int           // total attempts per request, 1 disables retries
+	PerTryTimeout  time.Duration // zero means attempts are only bound by the client
+	StatusCodes    map[int]bool  // upstream status codes that are retried
+	BudgetPercent  int           // retries allowed as a share of the active requests
+	MinConcurrency int           // retries always allowed regardless of the budget
+	MaxBodyBytes   int64         // largest request body buffered for replay
+}
+
+// Function to read the retry policy from the environment
+func LoadRetryConfig(logger *log.Logger) RetryConfig {
+	return RetryConfig{
+		Attempts:       max(envInt(logger, "RETRY_ATTEMPTS", 2), 1),
+		PerTryTimeout:  EnvDuration(logger, "RETRY_PER_TRY_TIMEOUT", 0),
+		StatusCodes:    parseStatusCodes(logger, envString("RETRY_STATUS_CODES", "502,503,504")),
+		BudgetPercent:  envInt(logger, "RETRY_BUDGET_PERCENT", 20),
+		MinConcurrency: envInt(logger, "RETRY_MIN_CONCURRENCY", 3),
+		MaxBodyBytes:   int64(envInt(logger, "RETRY_MAX_BODY_BYTES", 64*1024)),
+	}
+}
+
+// Function to parse a comma separated list of status codes
+func parseStatusCodes(logger *log.Logger, list string) map[int]bool {
+	codes := make(map[int]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil {
+			logger.Printf("Ignoring invalid retry status code %q", field)
+			continue
+		}
+		codes[code] = true
+	}
+	return codes
+}
+
+// Methods that can be sent twice without changing the outcome
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Caps the number of concurrent retries to a share of the active requests,
+// so a struggling pool is not buried under retry storms
+type retryBudget struct {
+	active  atomic.Int64
+	retries atomic.Int64
+}
+
+// Tries to take a retry out of the budget, must be paired with releaseRetry
+func (b *retryBudget) acquireRetry(config RetryConfig) bool {
+	allowed := max(int64(config.MinConcurrency), b.active.Load()*int64(config.BudgetPercent)/100)
+	if b.retries.Add(1) > allowed {
+		b.retries.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (b *retryBudget) releaseRetry() {
+	b.retries.Add(-1)
+}
+
+// Reads the request body into memory so it can be sent again on a retry.
+// Bodies above the limit are left streaming and the request is not replayable.
+func bufferBody(r *http.Request, limit int64) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	buffered, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(buffered)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buffered), r.Body), r.Body}
+		return nil, false, nil
+	}
+	r.Body.Close()
+	return buffered, true, nil
+}
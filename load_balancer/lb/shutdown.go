@@ -0,0 +1,90 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/file"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Method to shut the pool down once the listeners have stopped taking new
+// traffic. Waits for the outstanding requests and connections of the workers
+// until the context is done, closes the connections still open, stops the
+// health checks and writes the workers back to the node files.
+func (lb *LoadBalancer) Shutdown(ctx context.Context) error {
+	lb.stopping.Store(true)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for lb.inFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			lb.Logger.Printf("Shutdown deadline passed with %d request(s) and connection(s) still open", lb.inFlight())
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	for _, worker := range lb.WorkerList() {
+		worker.tunnels.closeAll()
+	}
+	if lb.HealthChecker != nil {
+		lb.HealthChecker.Stop()
+	}
+
+	err := lb.flushNodes()
+	lb.Logger.Printf("Pool stopped with %d worker(s), hedged requests: %d fired, %d won", len(lb.WorkerList()), lb.Hedges.Fired.Load(), lb.Hedges.Won.Load())
+	return err
+}
+
+// Reports whether the pool is shutting down, it must not be scaled anymore
+func (lb *LoadBalancer) Stopping() bool {
+	return lb.stopping.Load()
+}
+
+// Requests and connections outstanding across all worker nodes of the pool
+func (lb *LoadBalancer) inFlight() int64 {
+	var total int64
+	for _, worker := range lb.WorkerList() {
+		total += worker.InFlight()
+	}
+	return total
+}
+
+// Writes the worker nodes of the pool back to the node files. Workers in the
+// pool are listed in the available nodes file, except for workers that were
+// still being drained, which go to the standby nodes file.
+func (lb *LoadBalancer) flushNodes() error {
+	file.NodesMux.Lock()
+	defer file.NodesMux.Unlock()
+
+	var errs []error
+	for _, worker := range lb.WorkerList() {
+		node := Node{Address: worker.URL.Hostname(), Weight: worker.Weight}
+		target := lb.AvailableFile
+		if worker.draining.Load() {
+			if _, err := file.RemoveLine(lb.AvailableFile, matchNode(node.Address)); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			target = lb.StandbyFile
+		}
+
+		nodes, err := file.ReadIPAddresses(target)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		if !containsNode(nodes, node.Address) {
+			if err := file.AppendToFile(target, node.String()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("error writing the node files of pool %s: %v", lb.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Settings of the cookie based session affinity
+type StickyConfig struct {
+	Cookie string        // name of the affinity cookie, empty disables sticky sessions
+	TTL    time.Duration // lifetime of the cookie, refreshed while the client keeps coming back
+	Key    string        // secret the cookie is encrypted with
+}
+
+// Function to read the sticky session settings from the environment.
+// Pools other than the default pool get their own cookie, so a client
+// talking to several pools keeps one worker node per pool.
+func LoadStickyConfig(logger *log.Logger, pool string) StickyConfig {
+	sticky := StickyConfig{
+		Cookie: os.Getenv("STICKY_COOKIE"),
+		TTL:    EnvDuration(logger, "STICKY_TTL", time.Hour),
+		Key:    os.Getenv("STICKY_KEY"),
+	}
+	if sticky.Cookie != "" && pool != config.DefaultPool {
+		sticky.Cookie += "_" + pool
+	}
+	return sticky
+}
+
+// Returns the settings with the values set in the pool configuration applied on top
+func (c StickyConfig) merge(o config.Sticky) StickyConfig {
+	if o.Cookie != "" {
+		c.Cookie = o.Cookie
+	}
+	if o.TTL > 0 {
+		c.TTL = o.TTL
+	}
+	return c
+}
+
+// StickySessions pins clients to the worker node that served their first
+// request. The worker is stored in a cookie encrypted and authenticated with
+// AES-GCM, so clients can neither read nor forge it, and the pool name is
+// bound to the cookie so it is only valid for the pool that issued it.
+type StickySessions struct {
+	config StickyConfig
+	pool   []byte
+	aead   cipher.AEAD
+}
+
+func NewStickySessions(logger *log.Logger, pool string, config StickyConfig) (*StickySessions, error) {
+	key := []byte(config.Key)
+	if len(key) == 0 {
+		// Cookies only stay valid until the next restart of this load balancer
+		logger.Println("STICKY_KEY is not set, using a random key")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("error generating sticky session key: %v", err)
+		}
+	}
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if config.TTL <= 0 {
+		config.TTL = time.Hour
+	}
+	return &StickySessions{config: config, pool: []byte(pool), aead: aead}, nil
+}
+
+// Returns the worker node the request is pinned to if it is one of the
+// candidates, nil if the request has no valid cookie or the worker is gone
+func (s *StickySessions) worker(r *http.Request, candidates []*Worker) *Worker {
+	host, _, ok := s.decode(r)
+	if !ok {
+		return nil
+	}
+	for _, worker := range candidates {
+		if worker.URL.Host == host {
+			return worker
+		}
+	}
+	return nil
+}
+
+// Pins the client to the worker node that served the response. The cookie is
+// only rewritten when it points elsewhere or has used up half of its lifetime.
+func (s *StickySessions) stick(resp *http.Response, worker *Worker) {
+	host, expires, ok := s.decode(resp.Request)
+	if ok && host == worker.URL.Host && time.Until(expires) > s.config.TTL/2 {
+		return
+	}
+
+	value, err := s.encode(worker.URL.Host, time.Now().Add(s.config.TTL))
+	if err != nil {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     s.config.Cookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(s.config.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   resp.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
+
+// Encrypts the worker address and the expiry time into a cookie value
+func (s *StickySessions) encode(host string, expires time.Time) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	plain := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	plain = append(plain, host...)
+	sealed := s.aead.Seal(nonce, nonce, plain, s.pool)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypts the cookie of the request, ok is false for missing, forged or expired cookies
+func (s *StickySessions) decode(r *http.Request) (host string, expires time.Time, ok bool) {
+	if r == nil {
+		return "", time.Time{}, false
+	}
+	cookie, err := r.Cookie(s.config.Cookie)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", time.Time{}, false
+	}
+	nonce, sealed := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, s.pool)
+	if err != nil || len(plain) < 8 {
+		return "", time.Time{}, false
+	}
+	expires = time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)
+	if time.Now().After(expires) {
+		return "", time.Time{}, false
+	}
+	return string(plain[8:]), expires, true
+}
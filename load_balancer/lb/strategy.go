@@ -0,0 +1,199 @@
+package lb
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+)
+
+// Names of the supported balancing strategies
+const (
+	RoundRobin         = "round-robin"
+	WeightedRoundRobin = "weighted-round-robin"
+	LeastConnections   = "least-connections"
+	ConsistentHash     = "consistent-hash"
+	PowerOfTwoChoices  = "power-of-two-choices"
+)
+
+// Strategy decides which worker node in the pool serves the next request.
+// Next is always called with the load balancer lock held and a slice of
+// workers of which at least one is not excluded, so implementations do not
+// need their own synchronization. The request may be nil when the caller has
+// none to offer. Excluded workers were already tried for the request, retries
+// and hedges pick among the others without moving the shared state of the
+// strategy, so they do not skew the distribution of first attempts.
+type Strategy interface {
+	Next(workers []*Worker, r *http.Request, exclude map[*Worker]bool) *Worker
+}
+
+// Implemented by the strategies whose state depends on the worker weights,
+// reset drops that state when a weight changes
+type weightedStrategy interface {
+	reset()
+}
+
+// Function to create a balancing strategy from its configured name.
+// hashKey selects the request attribute used by the consistent-hash strategy.
+func NewStrategy(name, hashKey string) (Strategy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", RoundRobin:
+		return &roundRobinStrategy{}, nil
+	case WeightedRoundRobin:
+		return &weightedRoundRobinStrategy{current: make(map[*Worker]int)}, nil
+	case LeastConnections:
+		return &leastConnectionsStrategy{}, nil
+	case ConsistentHash:
+		return newConsistentHashStrategy(hashKey)
+	case PowerOfTwoChoices:
+		return &powerOfTwoChoicesStrategy{}, nil
+	}
+	return nil, fmt.Errorf("unknown balancing strategy: %s", name)
+}
+
+// Cycles through the worker nodes one after the other
+type roundRobinStrategy struct {
+	current int
+}
+
+func (s *roundRobinStrategy) Next(workers []*Worker, r *http.Request, exclude map[*Worker]bool) *Worker {
+	// Ensure current is within bounds, the pool may have shrunk since the last call
+	if s.current >= len(workers) {
+		s.current = 0
+	}
+
+	// Retries take the next worker that has not been tried, the cursor stays put
+	if len(exclude) > 0 {
+		for i := 0; i < len(workers); i++ {
+			if worker := workers[(s.current+i)%len(workers)]; !exclude[worker] {
+				return worker
+			}
+		}
+		return nil
+	}
+
+	worker := workers[s.current]
+	s.current = (s.current + 1) % len(workers)
+	return worker
+}
+
+// Smooth weighted round robin as implemented by nginx. On every pick each
+// worker's current weight grows by its configured weight, the heaviest worker
+// is selected and pushed back by the total weight. A pool weighted 5/1/1
+// yields a, a, b, a, c, a, a instead of a burst of five requests to a.
+type weightedRoundRobinStrategy struct {
+	current map[*Worker]int
+}
+
+func (s *weightedRoundRobinStrategy) Next(workers []*Worker, r *http.Request, exclude map[*Worker]bool) *Worker {
+	// Retries take the untried worker that is due next, the weights stay put
+	if len(exclude) > 0 {
+		var selected *Worker
+		for _, worker := range workers {
+			if !exclude[worker] && (selected == nil || s.current[worker] > s.current[selected]) {
+				selected = worker
+			}
+		}
+		return selected
+	}
+
+	// Forget the state of workers that have left the pool
+	if len(s.current) > len(workers) {
+		present := make(map[*Worker]int, len(workers))
+		for _, worker := range workers {
+			present[worker] = s.current[worker]
+		}
+		s.current = present
+	}
+
+	var selected *Worker
+	total := 0
+	for _, worker := range workers {
+		weight := worker.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		total += weight
+		s.current[worker] += weight
+		if selected == nil || s.current[worker] > s.current[selected] {
+			selected = worker
+		}
+	}
+
+	s.current[selected] -= total
+	return selected
+}
+
+func (s *weightedRoundRobinStrategy) reset() {
+	s.current = make(map[*Worker]int)
+}
+
+// Picks the worker node with the fewest outstanding requests.
+// Ties are broken in round robin order so idle pools still spread the load.
+type leastConnectionsStrategy struct {
+	current int
+}
+
+func (s *leastConnectionsStrategy) Next(workers []*Worker, r *http.Request, exclude map[*Worker]bool) *Worker {
+	if s.current >= len(workers) {
+		s.current = 0
+	}
+
+	var selected *Worker
+	for i := 0; i < len(workers); i++ {
+		worker := workers[(s.current+i)%len(workers)]
+		if exclude[worker] {
+			continue
+		}
+		if selected == nil || worker.InFlight() < selected.InFlight() {
+			selected = worker
+		}
+	}
+
+	// Only first attempts move the tie-break cursor
+	if len(exclude) == 0 {
+		s.current = (s.current + 1) % len(workers)
+	}
+	return selected
+}
+
+// Samples two distinct worker nodes at random and picks the one with the lower
+// load score. Sampling instead of scanning the pool keeps a briefly fast worker
+// from being flooded by every request at once.
+type powerOfTwoChoicesStrategy struct{}
+
+func (s *powerOfTwoChoicesStrategy) Next(workers []*Worker, r *http.Request, exclude map[*Worker]bool) *Worker {
+	if len(exclude) > 0 {
+		untried := make([]*Worker, 0, len(workers))
+		for _, worker := range workers {
+			if !exclude[worker] {
+				untried = append(untried, worker)
+			}
+		}
+		workers = untried
+	}
+	if len(workers) == 0 {
+		return nil
+	}
+	if len(workers) == 1 {
+		return workers[0]
+	}
+
+	i := rand.IntN(len(workers))
+	j := rand.IntN(len(workers) - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := workers[i], workers[j]
+	if loadScore(b) < loadScore(a) {
+		return b
+	}
+	return a
+}
+
+// Latency EWMA scaled by the requests already queued on the worker node.
+// Workers without samples score zero so they get probed early on.
+func loadScore(worker *Worker) float64 {
+	return float64(worker.Latency()) * float64(worker.InFlight()+1)
+}
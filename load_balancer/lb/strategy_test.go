@@ -0,0 +1,169 @@
+package lb
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Function to create worker nodes 10.0.0.1, 10.0.0.2, ... with the given weights
+func testWorkers(weights ...int) []*Worker {
+	workers := make([]*Worker, len(weights))
+	for i, weight := range weights {
+		workers[i] = NewWorker(&url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:8080", i+1)}, weight)
+	}
+	return workers
+}
+
+func TestStrategyRetries(t *testing.T) {
+	tests := []struct {
+		strategy string
+		weights  []int
+		failing  int   // index of the worker failing every request
+		requests int   // requests sent, each retried once when it hits the failing worker
+		first    []int // expected first attempts per worker
+	}{
+		{RoundRobin, []int{1, 1, 1}, 0, 30, []int{10, 10, 10}},
+		{RoundRobin, []int{1, 1, 1, 1}, 1, 40, []int{10, 10, 10, 10}},
+		{LeastConnections, []int{1, 1, 1}, 0, 30, []int{10, 10, 10}},
+		{LeastConnections, []int{1, 1, 1, 1}, 2, 40, []int{10, 10, 10, 10}},
+		{WeightedRoundRobin, []int{1, 1, 1}, 0, 30, []int{10, 10, 10}},
+		{WeightedRoundRobin, []int{2, 1, 1}, 0, 40, []int{20, 10, 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%v", tt.strategy, tt.weights), func(t *testing.T) {
+			strategy, err := NewStrategy(tt.strategy, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			workers := testWorkers(tt.weights...)
+			failing := workers[tt.failing]
+
+			first := make(map[*Worker]int)
+			served := make(map[*Worker]int)
+			for i := 0; i < tt.requests; i++ {
+				worker := strategy.Next(workers, nil, nil)
+				first[worker]++
+				if worker != failing {
+					served[worker]++
+					continue
+				}
+
+				retry := strategy.Next(workers, nil, map[*Worker]bool{failing: true})
+				if retry == nil || retry == failing {
+					t.Fatalf("request %d: retry picked %v", i, retry)
+				}
+				served[retry]++
+			}
+
+			for i, worker := range workers {
+				if first[worker] != tt.first[i] {
+					t.Errorf("worker %d got %d first attempts, want %d", i+1, first[worker], tt.first[i])
+				}
+				if worker != failing && served[worker] == 0 {
+					t.Errorf("worker %d served no requests", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestStrategyRetryExcludesAll(t *testing.T) {
+	workers := testWorkers(1, 1, 1)
+	exclude := map[*Worker]bool{workers[0]: true, workers[1]: true, workers[2]: true}
+
+	for _, name := range []string{RoundRobin, WeightedRoundRobin, LeastConnections, ConsistentHash, PowerOfTwoChoices} {
+		strategy, err := NewStrategy(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if worker := strategy.Next(workers, nil, exclude); worker != nil {
+			t.Errorf("%s picked %s although every worker was tried", name, worker.URL)
+		}
+	}
+}
+
+func TestWeightedRoundRobinOrder(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    string // indexes of the picked workers, one cycle repeated twice
+	}{
+		{[]int{4, 2, 1}, "01020100102010"},
+		{[]int{5, 1, 1}, "00102000010200"},
+		{[]int{1, 1, 1}, "012012"},
+		{[]int{3, 0}, "00100010"}, // weights below 1 count as 1
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.weights), func(t *testing.T) {
+			strategy, _ := NewStrategy(WeightedRoundRobin, "")
+			workers := testWorkers(tt.weights...)
+			index := make(map[*Worker]int)
+			for i, worker := range workers {
+				index[worker] = i
+			}
+
+			got := ""
+			for range tt.want {
+				got += fmt.Sprint(index[strategy.Next(workers, nil, nil)])
+			}
+			if got != tt.want {
+				t.Errorf("picked %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsistentHashRemap(t *testing.T) {
+	const keys = 10000
+	all := testWorkers(1, 1, 1, 1, 1, 1)
+
+	tests := []struct {
+		name   string
+		before []*Worker
+		after  []*Worker
+		moved  *Worker // the only worker keys may move from or to
+	}{
+		{"remove first", all[:5], all[1:5], all[0]},
+		{"remove middle", all[:5], append(append([]*Worker{}, all[:2]...), all[3:5]...), all[2]},
+		{"add", all[:5], all, all[5]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := NewStrategy(ConsistentHash, "header:X-User")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pick := func(workers []*Worker, key int) *Worker {
+				r, _ := http.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-User", fmt.Sprint("user-", key))
+				return strategy.Next(workers, r, nil)
+			}
+
+			before := make([]*Worker, keys)
+			for key := range before {
+				before[key] = pick(tt.before, key)
+			}
+			moved := 0
+			for key := range before {
+				after := pick(tt.after, key)
+				if after == before[key] {
+					continue
+				}
+				moved++
+				if before[key] != tt.moved && after != tt.moved {
+					t.Fatalf("key %d moved from %s to %s", key, before[key].URL, after.URL)
+				}
+			}
+
+			// About 1/N of the keys move, N being the size of the larger pool
+			n := max(len(tt.before), len(tt.after))
+			if expected := keys / n; moved < expected/2 || moved > expected*3/2 {
+				t.Errorf("%d of %d keys moved, want about %d", moved, keys, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Admission control of new connections, such as the scaling limits of a pool
+type Admission interface {
+	Acquire() bool
+	Release()
+}
+
+// TCPProxy accepts raw TCP connections and relays each of them to a worker
+// node of the pool. The worker is picked by the pool's strategy and an open
+// connection counts as an outstanding request of its worker for as long as
+// it lives, just like an upgraded connection.
+type TCPProxy struct {
+	lb        *LoadBalancer
+	config    config.TCPListener
+	admission Admission
+	listener  net.Listener
+	mux       sync.Mutex
+	closed    bool
+}
+
+func NewTCPProxy(lb *LoadBalancer, cfg config.TCPListener, admission Admission) *TCPProxy {
+	return &TCPProxy{lb: lb, config: cfg, admission: admission}
+}
+
+// ListenAndServe accepts connections on the configured address until Close is called
+func (p *TCPProxy) ListenAndServe() error {
+	listener, err := net.Listen("tcp", p.config.Listen)
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		listener.Close()
+		return nil
+	}
+	p.listener = listener
+	p.mux.Unlock()
+
+	p.lb.Logger.Printf("TCP proxy started on %s", p.config.Listen)
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// Back off on errors such as running out of file descriptors
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			p.lb.Logger.Printf("Error accepting TCP connection: %v, retrying in %s", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go p.serve(conn)
+	}
+}
+
+// Close stops accepting connections, open connections are left alone
+func (p *TCPProxy) Close() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.closed = true
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+// Relays one client connection to a worker node until either side is done
+func (p *TCPProxy) serve(client net.Conn) {
+	defer client.Close()
+
+	if p.admission != nil {
+		if !p.admission.Acquire() {
+			p.lb.Logger.Printf("Too many connections, refusing %s", client.RemoteAddr())
+			return
+		}
+		defer p.admission.Release()
+	}
+
+	worker, backend := p.connect(client)
+	if worker == nil {
+		return
+	}
+	defer worker.Done()
+	defer backend.Close()
+
+	t := newTunnel(client, client, backend, false, UpgradeConfig{IdleTimeout: p.config.IdleTimeout})
+	t.halfClose = true
+	worker.tunnels.add(t)
+	defer worker.tunnels.remove(t)
+
+	p.lb.Logger.Printf("Relaying TCP connection from %s to %s", client.RemoteAddr(), worker.URL.Host)
+	t.run()
+	p.lb.Logger.Printf("TCP connection from %s to %s closed", client.RemoteAddr(), worker.URL.Host)
+}
+
+// Dials a worker node for the client. Nothing has been sent yet when a dial
+// fails, so the next worker is tried up to the retry attempts of the pool.
+func (p *TCPProxy) connect(client net.Conn) (*Worker, net.Conn) {
+	r := addressRequest(client.RemoteAddr())
+	dialer := &net.Dialer{Timeout: p.config.ConnectTimeout}
+
+	tried := make(map[*Worker]bool)
+	for attempt := 1; attempt <= max(p.lb.Retries.Attempts, 1); attempt++ {
+		worker := p.lb.nextWorker(r, tried)
+		if worker == nil {
+			p.lb.Logger.Println("No available workers")
+			return nil, nil
+		}
+		tried[worker] = true
+
+		conn, err := dialer.Dial("tcp", worker.URL.Host)
+		if err == nil {
+			p.lb.record(worker, true)
+			return worker, conn
+		}
+		p.lb.Logger.Printf("Error connecting to %s: %v", worker.URL.Host, err)
+		p.lb.record(worker, false)
+		worker.Done()
+	}
+	return nil, nil
+}
+
+// Builds a request carrying nothing but the client address, so strategies and
+// sticky sessions can pick a worker for traffic that is not HTTP
+func addressRequest(client net.Addr) *http.Request {
+	return &http.Request{RemoteAddr: client.String(), URL: &url.URL{}, Header: make(http.Header)}
+}
+
+// Opens a TCP connection to the worker node, healthy if it is accepted
+func (hc *HealthChecker) probeTCP(worker *Worker) bool {
+	conn, err := net.DialTimeout("tcp", worker.URL.Host, hc.config.Timeout)
+	if err != nil {
+		hc.lb.Logger.Printf("Health check failed for %s: %v", worker.URL.Host, err)
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,127 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/certs"
+	"GoBalance/loadbalancer/lib/config"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Protocols spoken to the worker nodes
+const (
+	ProtocolAuto  = "auto"  // HTTP/2 if a TLS worker offers it, HTTP/1.1 otherwise
+	ProtocolHTTP1 = "http1" // HTTP/1.1 only
+	ProtocolH2    = "h2"    // HTTP/2 over TLS
+	ProtocolH2C   = "h2c"   // HTTP/2 over cleartext with prior knowledge
+)
+
+// Settings of the connections from the load balancer to its worker nodes
+type UpstreamTLSConfig struct {
+	Enabled    bool   // workers without an explicit scheme are reached over https
+	CAFile     string // PEM bundle verifying the worker certificates, the system roots if empty
+	CertFile   string // client certificate presented to the workers for mutual TLS
+	KeyFile    string
+	ServerName string // name verified in the worker certificates instead of their address
+}
+
+// Function to read the upstream TLS settings from the environment
+func LoadUpstreamTLSConfig(logger *log.Logger) UpstreamTLSConfig {
+	enabled, err := strconv.ParseBool(envString("UPSTREAM_TLS", "false"))
+	if err != nil {
+		logger.Printf("Error parsing UPSTREAM_TLS environment variable: %v. Using plain HTTP.", err)
+	}
+	return UpstreamTLSConfig{
+		Enabled:    enabled,
+		CAFile:     os.Getenv("UPSTREAM_CA_FILE"),
+		CertFile:   os.Getenv("UPSTREAM_CERT_FILE"),
+		KeyFile:    os.Getenv("UPSTREAM_KEY_FILE"),
+		ServerName: os.Getenv("UPSTREAM_SERVER_NAME"),
+	}
+}
+
+// Returns the settings with the values set in the pool configuration applied on top
+func (c UpstreamTLSConfig) merge(o config.UpstreamTLS) UpstreamTLSConfig {
+	if o.Enabled != nil {
+		c.Enabled = *o.Enabled
+	}
+	if o.CA != "" {
+		c.CAFile = o.CA
+	}
+	if o.Cert != "" {
+		c.CertFile = o.Cert
+	}
+	if o.Key != "" {
+		c.KeyFile = o.Key
+	}
+	if o.ServerName != "" {
+		c.ServerName = o.ServerName
+	}
+	return c
+}
+
+// Scheme of the worker URLs that do not name one
+func (c UpstreamTLSConfig) scheme() string {
+	if c.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
+// Function to build the transport shared by the reverse proxies, health
+// checks and stats requests of a pool. HTTP/2 transports multiplex all
+// requests to a worker node over a single connection.
+func newTransport(cfg UpstreamTLSConfig, protocol string) (http.RoundTripper, error) {
+	tlsConfig, err := certs.ClientConfig(cfg.CAFile, cfg.CertFile, cfg.KeyFile, cfg.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch protocol = strings.ToLower(strings.TrimSpace(protocol)); protocol {
+	case "", ProtocolAuto, ProtocolHTTP1:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		if protocol == ProtocolHTTP1 {
+			// A non-nil empty map turns off the HTTP/2 upgrade during the TLS handshake
+			transport.ForceAttemptHTTP2 = false
+			transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+		return transport, nil
+	case ProtocolH2:
+		if !cfg.Enabled {
+			return nil, fmt.Errorf("upstream protocol %s needs upstream TLS", ProtocolH2)
+		}
+		return newHTTP2Transport(tlsConfig), nil
+	case ProtocolH2C:
+		if cfg.Enabled {
+			return nil, fmt.Errorf("upstream protocol %s cannot be used with upstream TLS", ProtocolH2C)
+		}
+		transport := newHTTP2Transport(nil)
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return transport, nil
+	}
+	return nil, fmt.Errorf("unknown upstream protocol: %s", protocol)
+}
+
+// Function to create an HTTP/2 transport that pings idle connections, so a
+// worker node that went away is noticed before requests pile up on it
+func newHTTP2Transport(tlsConfig *tls.Config) *http2.Transport {
+	return &http2.Transport{
+		TLSClientConfig: tlsConfig,
+		ReadIdleTimeout: 30 * time.Second,
+		PingTimeout:     15 * time.Second,
+	}
+}
@@ -0,0 +1,283 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Largest datagram relayed in either direction
+const maxDatagramSize = 64 * 1024
+
+// UDPProxy balances the datagrams received on a listener across the worker
+// nodes of the pool. Every client gets a session with its own socket to each
+// worker it talks to, so the replies of the workers find their way back to the
+// client. Sessions are forgotten once they have been idle for the session timeout.
+type UDPProxy struct {
+	lb        *LoadBalancer
+	config    config.UDPListener
+	admission Admission
+	conn      *net.UDPConn
+	sessions  map[string]*udpSession
+	stop      chan struct{}
+	mux       sync.Mutex
+	closed    bool
+}
+
+func NewUDPProxy(lb *LoadBalancer, cfg config.UDPListener, admission Admission) *UDPProxy {
+	return &UDPProxy{
+		lb:        lb,
+		config:    cfg,
+		admission: admission,
+		sessions:  make(map[string]*udpSession),
+		stop:      make(chan struct{}),
+	}
+}
+
+// ListenAndServe receives datagrams on the configured address until Close is called
+func (p *UDPProxy) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", p.config.Listen)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		conn.Close()
+		return nil
+	}
+	p.conn = conn
+	p.mux.Unlock()
+
+	go p.expireSessions()
+	if p.config.Affinity {
+		p.lb.Logger.Printf("UDP proxy started on %s with session affinity", p.config.Listen)
+	} else {
+		p.lb.Logger.Printf("UDP proxy started on %s", p.config.Listen)
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			p.lb.Logger.Printf("Error receiving UDP datagram: %v", err)
+			continue
+		}
+		session := p.session(client)
+		if session == nil {
+			continue
+		}
+		p.forward(session, buf[:n])
+	}
+}
+
+// Close stops receiving datagrams and forgets all sessions
+func (p *UDPProxy) Close() error {
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	sessions := p.sessions
+	p.sessions = make(map[string]*udpSession)
+	conn := p.conn
+	p.mux.Unlock()
+
+	for _, session := range sessions {
+		p.closeSession(session)
+	}
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// Returns the session of the client, starting one if it has none.
+// Returns nil if the pool is at its limit.
+func (p *UDPProxy) session(client *net.UDPAddr) *udpSession {
+	key := client.String()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if session, ok := p.sessions[key]; ok {
+		return session
+	}
+	if p.admission != nil && !p.admission.Acquire() {
+		p.lb.Logger.Printf("Too many UDP sessions, dropping datagram from %s", client)
+		return nil
+	}
+	session := &udpSession{client: client, upstreams: make(map[*Worker]*net.UDPConn)}
+	session.active.Store(time.Now().UnixNano())
+	p.sessions[key] = session
+	return session
+}
+
+// Sends the datagram of a client to a worker node, the pinned one with session affinity
+func (p *UDPProxy) forward(session *udpSession, datagram []byte) {
+	session.active.Store(time.Now().UnixNano())
+	session.mux.Lock()
+	defer session.mux.Unlock()
+	if session.closed {
+		// The session expired in the meantime, the client has to send again
+		return
+	}
+
+	var worker *Worker
+	if p.config.Affinity {
+		// The pinned worker holds an outstanding request for the whole session,
+		// a new one is picked once it stops taking traffic
+		if session.worker != nil && !p.lb.usable(session.worker) {
+			session.worker.Done()
+			session.worker = nil
+		}
+		if session.worker == nil {
+			session.worker = p.lb.nextWorker(addressRequest(session.client), nil)
+		}
+		worker = session.worker
+	} else {
+		worker = p.lb.nextWorker(addressRequest(session.client), nil)
+		if worker != nil {
+			defer worker.Done()
+		}
+	}
+	if worker == nil {
+		p.lb.Logger.Println("No available workers")
+		return
+	}
+
+	upstream, ok := session.upstreams[worker]
+	if !ok {
+		addr, err := net.ResolveUDPAddr("udp", p.target(worker))
+		if err == nil {
+			upstream, err = net.DialUDP("udp", nil, addr)
+		}
+		if err != nil {
+			p.lb.Logger.Printf("Error connecting to %s: %v", p.target(worker), err)
+			p.lb.record(worker, false)
+			return
+		}
+		session.upstreams[worker] = upstream
+		go p.relayReplies(session, worker, upstream)
+	}
+	if _, err := upstream.Write(datagram); err != nil {
+		p.lb.Logger.Printf("Error sending datagram to %s: %v", p.target(worker), err)
+	}
+}
+
+// Address the datagrams for the worker node are sent to
+func (p *UDPProxy) target(worker *Worker) string {
+	if p.config.Port == 0 {
+		return worker.URL.Host
+	}
+	return net.JoinHostPort(worker.URL.Hostname(), strconv.Itoa(p.config.Port))
+}
+
+// Sends the replies of a worker node back to the client until the session ends
+func (p *UDPProxy) relayReplies(session *udpSession, worker *Worker, upstream *net.UDPConn) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := upstream.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Nothing listens on the worker port, reported back by an ICMP port unreachable
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				p.lb.Logger.Printf("Worker %s refused a datagram", p.target(worker))
+				p.lb.record(worker, false)
+				continue
+			}
+			p.lb.Logger.Printf("Error receiving datagram from %s: %v", p.target(worker), err)
+			return
+		}
+		// A reply shows the worker is listening, it ends a run of refusals
+		p.lb.record(worker, true)
+		session.active.Store(time.Now().UnixNano())
+		if _, err := p.conn.WriteToUDP(buf[:n], session.client); err != nil {
+			p.lb.Logger.Printf("Error sending reply to %s: %v", session.client, err)
+		}
+	}
+}
+
+// Forgets the sessions that have been idle for the session timeout
+func (p *UDPProxy) expireSessions() {
+	ticker := time.NewTicker(max(min(p.config.SessionTimeout/4, 10*time.Second), time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+
+		var expired []*udpSession
+		p.mux.Lock()
+		for key, session := range p.sessions {
+			if time.Since(time.Unix(0, session.active.Load())) >= p.config.SessionTimeout {
+				delete(p.sessions, key)
+				expired = append(expired, session)
+			}
+		}
+		p.mux.Unlock()
+
+		for _, session := range expired {
+			p.closeSession(session)
+		}
+	}
+}
+
+// Closes the sockets of a forgotten session and releases its worker
+func (p *UDPProxy) closeSession(session *udpSession) {
+	session.mux.Lock()
+	defer session.mux.Unlock()
+
+	session.closed = true
+	for _, upstream := range session.upstreams {
+		upstream.Close()
+	}
+	if session.worker != nil {
+		session.worker.Done()
+		session.worker = nil
+	}
+	if p.admission != nil {
+		p.admission.Release()
+	}
+}
+
+// Datagrams exchanged with one client
+type udpSession struct {
+	client    *net.UDPAddr
+	worker    *Worker // pinned worker with session affinity
+	upstreams map[*Worker]*net.UDPConn
+	active    atomic.Int64 // unix nanoseconds of the last datagram in either direction
+	closed    bool
+	mux       sync.Mutex
+}
+
+// Reports whether the worker node is still in the pool and takes traffic,
+// the same checks nextWorker applies to a new pick
+func (lb *LoadBalancer) usable(worker *Worker) bool {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	for _, w := range lb.Workers {
+		if w == worker {
+			return worker.Healthy() && !worker.Ejected() && worker.Accepting() && (worker.Breaker == nil || worker.Breaker.Ready())
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUsableOpenBreaker(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	pool := NewLoadBalancer(logger, nil)
+	pool.Workers = testWorkers(1, 1)
+	worker := pool.Workers[0]
+	if !pool.usable(worker) {
+		t.Fatal("a healthy worker without a breaker is not usable")
+	}
+
+	worker.Breaker = NewCircuitBreaker(worker.URL.Host, BreakerConfig{Window: time.Minute, MinRequests: 1, ErrorPercent: 50, OpenTimeout: time.Minute, HalfOpenRequests: 1}, logger)
+	worker.Breaker.Record(false)
+	if worker.Breaker.State() != BreakerOpen {
+		t.Fatalf("breaker is %v after a failure, want open", worker.Breaker.State())
+	}
+	// A session pinned to the worker must move to another one
+	if pool.usable(worker) {
+		t.Error("a worker with an open breaker is still usable")
+	}
+	if !pool.usable(pool.Workers[1]) {
+		t.Error("the other worker is not usable")
+	}
+}
+
+func TestUDPReplyResetsConsecutiveErrors(t *testing.T) {
+	// Find a free port for the worker, nothing listens on it yet
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workerAddr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	strategy, _ := NewStrategy(RoundRobin, "")
+	pool := NewLoadBalancer(log.New(io.Discard, "", 0), strategy)
+	pool.Outliers = NewOutlierDetector(pool, OutlierConfig{ConsecutiveErrors: 2, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Minute, MaxEjectionPercent: 100})
+	worker := NewWorker(&url.URL{Scheme: "http", Host: workerAddr.String()}, 1)
+	pool.Workers = []*Worker{worker}
+
+	proxy := NewUDPProxy(pool, config.UDPListener{Listen: "127.0.0.1:0", Affinity: true, SessionTimeout: time.Minute}, nil)
+	go proxy.ListenAndServe()
+	defer proxy.Close()
+	var listen *net.UDPAddr
+	for i := 0; listen == nil && i < 100; i++ {
+		proxy.mux.Lock()
+		if proxy.conn != nil {
+			listen = proxy.conn.LocalAddr().(*net.UDPAddr)
+		}
+		proxy.mux.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if listen == nil {
+		t.Fatal("UDP proxy did not start")
+	}
+
+	client, err := net.DialUDP("udp", nil, listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// Sends a datagram and waits for the worker's error count to reach want
+	send := func(want int) {
+		t.Helper()
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			worker.outlier.mux.Lock()
+			count := worker.outlier.consecutiveErrors
+			worker.outlier.mux.Unlock()
+			if count == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d consecutive errors, want %d", count, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Refused, then answered, then refused again
+	send(1)
+	echo, err := net.ListenUDP("udp", workerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 64)
+		n, from, err := echo.ReadFromUDP(buf)
+		if err == nil {
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+	send(0)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 64)); err != nil {
+		t.Fatalf("no reply relayed: %v", err)
+	}
+	echo.Close()
+	send(1)
+
+	if worker.Ejected() {
+		t.Error("worker ejected although its refusals were not consecutive")
+	}
+}
@@ -0,0 +1,375 @@
+package lb
+
+import (
+	"GoBalance/loadbalancer/lib/certs"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of upgraded connections such as WebSockets
+type UpgradeConfig struct {
+	IdleTimeout  time.Duration // connections without traffic in either direction are closed, zero disables
+	CloseTimeout time.Duration // time given to both sides to finish the close handshake
+}
+
+// Function to read the upgraded connection settings from the environment
+func LoadUpgradeConfig(logger *log.Logger) UpgradeConfig {
+	return UpgradeConfig{
+		IdleTimeout:  EnvDuration(logger, "UPGRADE_IDLE_TIMEOUT", 5*time.Minute),
+		CloseTimeout: EnvDuration(logger, "UPGRADE_CLOSE_TIMEOUT", 5*time.Second),
+	}
+}
+
+// Function to build the HTTP/1.1 transport the upgrade handshakes go through.
+// Upgrades cannot be multiplexed, so this ignores the upstream protocol.
+func newUpgradeTransport(cfg UpstreamTLSConfig) (*http.Transport, error) {
+	tlsConfig, err := certs.ClientConfig(cfg.CAFile, cfg.CertFile, cfg.KeyFile, cfg.ServerName)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	return transport, nil
+}
+
+// Reports whether the client asks to switch protocols, e.g. to a WebSocket
+func IsUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Proxies an upgrade request to a worker node and then relays the connection
+// in both directions until either side closes it. The connection counts as an
+// outstanding request of the worker for its whole lifetime, so it weighs in
+// on least-connections. Upgrades are neither retried nor hedged.
+func (lb *LoadBalancer) upgrade(w http.ResponseWriter, r *http.Request) {
+	worker := lb.nextWorker(r, nil)
+	if worker == nil {
+		lb.Logger.Println("No available workers")
+		http.Error(w, "No available workers", http.StatusServiceUnavailable)
+		return
+	}
+	defer worker.Done()
+
+	outreq := r.Clone(r.Context())
+	outreq.RequestURI = ""
+	outreq.URL.Scheme = worker.URL.Scheme
+	outreq.URL.Host = worker.URL.Host
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := outreq.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		outreq.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := lb.upgrades.RoundTrip(outreq)
+	if err != nil {
+		lb.Logger.Printf("Error proxying upgrade request to %s: %v", worker.URL, err)
+		lb.record(worker, false)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	lb.record(worker, resp.StatusCode < http.StatusInternalServerError)
+
+	// The worker refused to switch protocols, pass its answer on
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), r.Header.Get("Upgrade")) {
+		resp.Body.Close()
+		lb.Logger.Printf("Worker %s switched to an unexpected protocol %q", worker.URL, resp.Header.Get("Upgrade"))
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer backConn.Close()
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		lb.Logger.Printf("Error taking over the client connection: %v", err)
+		http.Error(w, "Protocol upgrades need HTTP/1.1", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// Hand the worker's 101 response to the client
+	fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	websocket := strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+	t := newTunnel(conn, brw.Reader, backConn, websocket, lb.Upgrades)
+	worker.tunnels.add(t)
+	defer worker.tunnels.remove(t)
+
+	lb.Logger.Printf("Worker at %s accepted %s connection", worker.URL, resp.Header.Get("Upgrade"))
+	t.run()
+	lb.Logger.Printf("%s connection to %s closed", resp.Header.Get("Upgrade"), worker.URL)
+}
+
+// Upgraded and TCP connections currently relayed to a worker node
+type tunnelSet struct {
+	mux sync.Mutex
+	set map[*tunnel]struct{}
+}
+
+func (s *tunnelSet) add(t *tunnel) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.set == nil {
+		s.set = make(map[*tunnel]struct{})
+	}
+	s.set[t] = struct{}{}
+}
+
+func (s *tunnelSet) remove(t *tunnel) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.set, t)
+}
+
+// Closes all connections gracefully, WebSockets get a close frame first
+func (s *tunnelSet) closeAll() {
+	s.mux.Lock()
+	tunnels := make([]*tunnel, 0, len(s.set))
+	for t := range s.set {
+		tunnels = append(tunnels, t)
+	}
+	s.mux.Unlock()
+
+	for _, t := range tunnels {
+		t.close()
+	}
+}
+
+// Number of upgraded and TCP connections open to the worker node
+func (w *Worker) Tunnels() int {
+	w.tunnels.mux.Lock()
+	defer w.tunnels.mux.Unlock()
+	return len(w.tunnels.set)
+}
+
+// One upgraded or TCP connection relayed between a client and a worker node
+type tunnel struct {
+	client       net.Conn
+	clientReader io.Reader // reads the client connection including what was buffered during the handshake
+	worker       io.ReadWriteCloser
+	toClient     *tunnelHalf
+	toWorker     *tunnelHalf
+	config       UpgradeConfig
+	halfClose    bool         // a side that stops sending only closes the write half of the other side
+	active       atomic.Int64 // unix nanoseconds of the last traffic in either direction
+	peerCloses   atomic.Int32 // close frames the client and the worker answered our close frames with
+	once         sync.Once
+}
+
+func newTunnel(client net.Conn, clientReader io.Reader, worker io.ReadWriteCloser, websocket bool, config UpgradeConfig) *tunnel {
+	t := &tunnel{
+		client:       client,
+		clientReader: clientReader,
+		worker:       worker,
+		toClient:     &tunnelHalf{dst: client},
+		toWorker:     &tunnelHalf{dst: worker, masked: true},
+		config:       config,
+	}
+	if websocket {
+		t.toClient.frames = &frameTracker{}
+		t.toWorker.frames = &frameTracker{}
+		t.toClient.onPeerClose = t.peerClosed
+		t.toWorker.onPeerClose = t.peerClosed
+	}
+	t.active.Store(time.Now().UnixNano())
+	return t
+}
+
+// Relays traffic until either side closes the connection or it is closed for
+// being idle. With half-closes the connection lasts until both sides are done.
+func (t *tunnel) run() {
+	errc := make(chan error, 2)
+	go func() { errc <- t.copy(t.toWorker, t.clientReader) }()
+	go func() { errc <- t.copy(t.toClient, t.worker) }()
+	finished := 0
+
+	var idle <-chan time.Time
+	if t.config.IdleTimeout > 0 {
+		ticker := time.NewTicker(max(min(t.config.IdleTimeout/4, 10*time.Second), time.Millisecond))
+		defer ticker.Stop()
+		idle = ticker.C
+	}
+
+	for {
+		select {
+		case err := <-errc:
+			finished++
+			if t.halfClose && err == nil && finished < 2 {
+				continue
+			}
+			t.shutdown()
+			return
+		case <-idle:
+			if time.Since(time.Unix(0, t.active.Load())) >= t.config.IdleTimeout {
+				t.close()
+				idle = nil
+			}
+		}
+	}
+}
+
+// Copies one direction of the connection, frame by frame for WebSockets so a
+// close frame can be slipped in between two frames. Returns nil if the source
+// finished sending and the write half of the destination could be closed.
+func (t *tunnel) copy(half *tunnelHalf, src io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.active.Store(time.Now().UnixNano())
+			if werr := half.write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			if err == io.EOF && t.halfClose && half.closeWrite() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Starts the graceful close. WebSockets get a going away close frame in both
+// directions, the close frames the client and the worker answer with are
+// passed on and the connection is cut once both are through, or once the close
+// timeout has passed.
+func (t *tunnel) close() {
+	if t.toClient.frames != nil {
+		t.toClient.sendClose()
+		t.toWorker.sendClose()
+		time.AfterFunc(t.config.CloseTimeout, t.shutdown)
+		return
+	}
+	t.shutdown()
+}
+
+// Counts a close frame answering one of ours, the close handshake is complete
+// once both the client and the worker have answered
+func (t *tunnel) peerClosed() {
+	if t.peerCloses.Add(1) == 2 {
+		t.shutdown()
+	}
+}
+
+// Cuts both sides of the connection
+func (t *tunnel) shutdown() {
+	t.once.Do(func() {
+		t.client.Close()
+		t.worker.Close()
+	})
+}
+
+// One direction of an upgraded connection
+type tunnelHalf struct {
+	mux     sync.Mutex
+	dst     io.Writer
+	frames  *frameTracker // nil unless the connection is a WebSocket
+	masked  bool          // frames towards the worker must be masked
+	closing bool          // a close frame goes out at the next frame boundary
+	closed  bool          // our close frame is out, only the answering close frame is forwarded
+	done    bool          // the answering close frame is through, nothing more is forwarded
+
+	onPeerClose func() // called once the close frame answering ours has been forwarded
+}
+
+// Forwards the data, splitting it at frame boundaries to send a pending close frame
+func (h *tunnelHalf) write(p []byte) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	for len(p) > 0 && !h.done {
+		n := len(p)
+		if h.frames != nil {
+			n = h.frames.next(p)
+		}
+		// After our close frame the other side only gets the close frame that
+		// answers it, data frames still on their way are dropped
+		answer := h.closed && h.frames.opcode() == opClose
+		if !h.closed || answer {
+			if _, err := h.dst.Write(p[:n]); err != nil {
+				return err
+			}
+		}
+		p = p[n:]
+		if h.frames == nil || !h.frames.atBoundary() {
+			continue
+		}
+		if answer {
+			// Nothing follows a close frame
+			h.done = true
+			if h.onPeerClose != nil {
+				h.onPeerClose()
+			}
+			return nil
+		}
+		if h.closing {
+			h.writeClose()
+		}
+	}
+	return nil
+}
+
+// Sends a close frame now if no frame is half written, at the next frame boundary otherwise
+func (h *tunnelHalf) sendClose() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.closed || h.frames == nil {
+		return
+	}
+	if h.frames.atBoundary() {
+		h.writeClose()
+		return
+	}
+	h.closing = true
+}
+
+// Closes the write half of the destination, false if it cannot be half-closed
+func (h *tunnelHalf) closeWrite() bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	conn, ok := h.dst.(interface{ CloseWrite() error })
+	return ok && conn.CloseWrite() == nil
+}
+
+func (h *tunnelHalf) writeClose() {
+	h.dst.Write(closeFrame(h.masked))
+	h.closing = false
+	h.closed = true
+}
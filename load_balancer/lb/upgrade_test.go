@@ -0,0 +1,125 @@
+package lb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Function to build a WebSocket frame, masked with a fixed key if masked is set
+func wsFrame(opcode byte, payload []byte, masked bool) []byte {
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	key := []byte{1, 2, 3, 4}
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
+
+// Reads one small WebSocket frame and returns its first byte and whether it was masked
+func readFrame(t *testing.T, conn net.Conn) (byte, bool) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	masked := header[1]&0x80 != 0
+	rest := int(header[1] & 0x7f)
+	if masked {
+		rest += 4
+	}
+	if _, err := io.ReadFull(conn, make([]byte, rest)); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	return header[0], masked
+}
+
+func TestTunnelCloseHandshake(t *testing.T) {
+	client, clientSide := net.Pipe()
+	worker, workerSide := net.Pipe()
+	defer client.Close()
+	defer worker.Close()
+
+	tun := newTunnel(clientSide, clientSide, workerSide, true, UpgradeConfig{CloseTimeout: time.Minute})
+	done := make(chan struct{})
+	go func() {
+		tun.run()
+		close(done)
+	}()
+
+	// The proxy goes away, both sides get its close frame
+	go tun.close()
+	if first, masked := readFrame(t, client); first != 0x88 || masked {
+		t.Fatalf("client got frame %#x (masked %v), want an unmasked close frame", first, masked)
+	}
+	if first, masked := readFrame(t, worker); first != 0x88 || !masked {
+		t.Fatalf("worker got frame %#x (masked %v), want a masked close frame", first, masked)
+	}
+
+	// Data still on its way is dropped, the answering close frames are passed on
+	go func() {
+		client.Write(wsFrame(0x1, []byte("late"), true))
+		client.Write(wsFrame(opClose, binary.BigEndian.AppendUint16(nil, 1000), true))
+	}()
+	if first, masked := readFrame(t, worker); first != 0x88 || !masked {
+		t.Fatalf("worker got frame %#x (masked %v), want the client's close frame", first, masked)
+	}
+	go worker.Write(wsFrame(opClose, binary.BigEndian.AppendUint16(nil, 1000), false))
+	if first, masked := readFrame(t, client); first != 0x88 || masked {
+		t.Fatalf("client got frame %#x (masked %v), want the worker's close frame", first, masked)
+	}
+
+	// Both close frames are through, the connection ends long before the close timeout
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("tunnel still open after the close handshake")
+	}
+}
+
+func TestTunnelHalfForwardsOnlyTheAnsweringClose(t *testing.T) {
+	var dst bytes.Buffer
+	answered := 0
+	half := &tunnelHalf{dst: &dst, frames: &frameTracker{}, onPeerClose: func() { answered++ }}
+	half.sendClose()
+	dst.Reset()
+
+	closing := wsFrame(opClose, binary.BigEndian.AppendUint16(nil, 1000), false)
+	stream := append(wsFrame(0x2, []byte{1, 2, 3}, false), closing...)
+	stream = append(stream, wsFrame(0x1, []byte("after"), false)...)
+	// Feed the stream a byte at a time so the close frame is split across writes
+	for i := range stream {
+		if err := half.write(stream[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(dst.Bytes(), closing) {
+		t.Errorf("forwarded % x, want % x", dst.Bytes(), closing)
+	}
+	if answered != 1 {
+		t.Errorf("answering close seen %d times, want 1", answered)
+	}
+}
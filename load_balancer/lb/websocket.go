@@ -0,0 +1,105 @@
+package lb
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// WebSocket close status telling the peer the endpoint is going away
+const closeGoingAway = 1001
+
+// Opcode of WebSocket close frames
+const opClose = 0x8
+
+// Follows the frame headers of a WebSocket stream (RFC 6455, section 5.2)
+// without looking at the payload, so the proxy knows where frames end
+type frameTracker struct {
+	header    [14]byte
+	read      int    // bytes of the current frame header seen so far
+	remaining uint64 // payload bytes of the current frame still to come
+}
+
+// Returns how many bytes of p belong to the current frame. p[:n] either ends
+// exactly at a frame boundary or covers all of p.
+func (t *frameTracker) next(p []byte) int {
+	n := 0
+	for n < len(p) {
+		if t.remaining > 0 {
+			k := min(uint64(len(p)-n), t.remaining)
+			t.remaining -= k
+			n += int(k)
+			if t.remaining == 0 {
+				return n
+			}
+			continue
+		}
+
+		t.header[t.read] = p[n]
+		t.read++
+		n++
+		if size := t.headerSize(); size > 0 && t.read == size {
+			t.remaining = t.payloadLength()
+			t.read = 0
+			if t.remaining == 0 {
+				return n
+			}
+		}
+	}
+	return n
+}
+
+// Reports whether the stream is between two frames
+func (t *frameTracker) atBoundary() bool {
+	return t.read == 0 && t.remaining == 0
+}
+
+// Opcode of the current frame, or of the last one at a frame boundary
+func (t *frameTracker) opcode() byte {
+	return t.header[0] & 0x0f
+}
+
+// Size of the current frame header, zero while the length byte is still missing
+func (t *frameTracker) headerSize() int {
+	if t.read < 2 {
+		return 0
+	}
+	size := 2
+	switch t.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if t.header[1]&0x80 != 0 {
+		size += 4 // masking key
+	}
+	return size
+}
+
+func (t *frameTracker) payloadLength() uint64 {
+	switch length := t.header[1] & 0x7f; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(t.header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(t.header[2:10])
+	default:
+		return uint64(length)
+	}
+}
+
+// Builds a close frame with the going away status. Frames sent to a server
+// must be masked, frames sent to a client must not.
+func closeFrame(masked bool) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, closeGoingAway)
+	if !masked {
+		return append([]byte{0x88, byte(len(payload))}, payload...)
+	}
+
+	key := make([]byte, 4)
+	rand.Read(key)
+	frame := append([]byte{0x88, 0x80 | byte(len(payload))}, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
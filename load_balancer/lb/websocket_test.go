@@ -0,0 +1,116 @@
+package lb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// Function to build a WebSocket frame with the given first byte, e.g. a
+// fragment without the FIN bit
+func wsFragment(first byte, payload []byte, masked bool) []byte {
+	frame := wsFrame(first&0x0f, payload, masked)
+	frame[0] = first
+	return frame
+}
+
+func TestFrameTracker(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"small text", [][]byte{wsFrame(0x1, []byte("hello"), false)}},
+		{"masked", [][]byte{wsFrame(0x1, []byte("hello"), true), wsFrame(0x2, []byte{0, 1, 2, 3, 4, 5, 6, 7}, true)}},
+		{"empty payloads", [][]byte{wsFrame(0x9, nil, false), wsFrame(opClose, nil, true)}},
+		{"16-bit length", [][]byte{wsFrame(0x2, bytes.Repeat([]byte{7}, 300), false), wsFrame(0x2, bytes.Repeat([]byte{7}, 0xffff), true)}},
+		{"64-bit length", [][]byte{wsFrame(0x2, bytes.Repeat([]byte{9}, 70000), false), wsFrame(0x1, []byte("next"), false)}},
+		{"64-bit length masked", [][]byte{wsFrame(0x2, bytes.Repeat([]byte{9}, 70000), true)}},
+		{"control frames between fragments", [][]byte{
+			wsFragment(0x01, []byte("first "), true),
+			wsFrame(0x9, []byte("ping"), true),
+			wsFragment(0x00, []byte("second "), true),
+			wsFrame(opClose, []byte{0x03, 0xe8}, true),
+			wsFragment(0x80, []byte("last"), true),
+		}},
+	}
+	chunkSizes := []int{0, 1, 2, 3, 5, 9, 14, 1000} // 0 feeds the whole stream at once
+
+	for _, tt := range tests {
+		var stream []byte
+		var ends []int
+		var opcodes []byte
+		for _, frame := range tt.frames {
+			stream = append(stream, frame...)
+			ends = append(ends, len(stream))
+			opcodes = append(opcodes, frame[0]&0x0f)
+		}
+
+		for _, size := range chunkSizes {
+			t.Run(fmt.Sprintf("%s/chunks of %d", tt.name, size), func(t *testing.T) {
+				tracker := &frameTracker{}
+				var gotEnds []int
+				var gotOpcodes []byte
+				offset := 0
+				for offset < len(stream) {
+					chunk := stream[offset:]
+					if size > 0 && len(chunk) > size {
+						chunk = chunk[:size]
+					}
+					// Like tunnelHalf.write, walk the chunk frame by frame
+					for len(chunk) > 0 {
+						n := tracker.next(chunk)
+						if n <= 0 || n > len(chunk) {
+							t.Fatalf("next returned %d for %d bytes", n, len(chunk))
+						}
+						chunk = chunk[n:]
+						offset += n
+						if tracker.atBoundary() {
+							gotEnds = append(gotEnds, offset)
+							gotOpcodes = append(gotOpcodes, tracker.opcode())
+						}
+					}
+				}
+
+				if fmt.Sprint(gotEnds) != fmt.Sprint(ends) {
+					t.Errorf("frame boundaries at %v, want %v", gotEnds, ends)
+				}
+				if !bytes.Equal(gotOpcodes, opcodes) {
+					t.Errorf("opcodes %v, want %v", gotOpcodes, opcodes)
+				}
+			})
+		}
+	}
+}
+
+func TestFrameTrackerSplitHeader(t *testing.T) {
+	// The 64-bit length arrives in pieces, nothing is at a boundary until the payload is through
+	frame := wsFrame(0x2, bytes.Repeat([]byte{1}, 70000), true)
+	tracker := &frameTracker{}
+	for i, piece := range [][]byte{frame[:1], frame[1:2], frame[2:6], frame[6:10], frame[10:13], frame[13:14], frame[14:100]} {
+		if n := tracker.next(piece); n != len(piece) {
+			t.Fatalf("piece %d: next returned %d, want %d", i, n, len(piece))
+		}
+		if tracker.atBoundary() {
+			t.Fatalf("piece %d: at a boundary in the middle of the frame", i)
+		}
+	}
+	if tracker.remaining != 70000-86 {
+		t.Fatalf("remaining %d, want %d", tracker.remaining, 70000-86)
+	}
+	if n := tracker.next(frame[100:]); n != len(frame)-100 || !tracker.atBoundary() {
+		t.Fatalf("next returned %d at boundary %v, want %d at the boundary", n, tracker.atBoundary(), len(frame)-100)
+	}
+}
+
+func TestCloseFrame(t *testing.T) {
+	for _, masked := range []bool{false, true} {
+		frame := closeFrame(masked)
+		tracker := &frameTracker{}
+		if n := tracker.next(frame); n != len(frame) || !tracker.atBoundary() || tracker.opcode() != opClose {
+			t.Errorf("masked %v: close frame % x is not a single close frame", masked, frame)
+		}
+		if (frame[1]&0x80 != 0) != masked {
+			t.Errorf("masked %v: mask bit of % x is wrong", masked, frame)
+		}
+	}
+}
@@ -5,31 +5,121 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Weight given to the newest sample in the latency moving average
+const latencyDecay = 0.3
+
 type Worker struct {
 	URL          *url.URL
+	Weight       int
 	ReverseProxy *httputil.ReverseProxy
+	Breaker      *CircuitBreaker
+	inFlight     atomic.Int64
+	latency      float64 // EWMA of response latency in nanoseconds
+	latencyMux   sync.Mutex
+	healthy      atomic.Bool
+	disabled     atomic.Bool  // taken out of rotation through the admin API
+	draining     atomic.Bool  // finishing its outstanding requests before it is removed
+	drainStarted atomic.Int64 // unix nanoseconds the running drain started at, zero if none
+	successes    int          // consecutive passed health checks, owned by the HealthChecker
+	failures     int          // consecutive failed health checks, owned by the HealthChecker
+	outlier      outlierState
+	tunnels      tunnelSet
+}
+
+// Function to create a worker node proxying to the given URL.
+// Workers start out healthy until the health checks say otherwise.
+func NewWorker(url *url.URL, weight int) *Worker {
+	worker := &Worker{
+		URL:          url,
+		Weight:       weight,
+		ReverseProxy: httputil.NewSingleHostReverseProxy(url),
+	}
+	worker.healthy.Store(true)
+	return worker
+}
+
+// Reports whether the worker node passes its health checks
+func (w *Worker) Healthy() bool {
+	return w.healthy.Load()
+}
+
+// Reports whether the worker node may be given new traffic as far as the admin API is concerned
+func (w *Worker) Accepting() bool {
+	return !w.disabled.Load() && !w.draining.Load()
+}
+
+// State of the worker node: draining, disabled, unhealthy, ejected or active
+func (w *Worker) State() string {
+	switch {
+	case w.draining.Load():
+		return "draining"
+	case w.disabled.Load():
+		return "disabled"
+	case !w.Healthy():
+		return "unhealthy"
+	case w.Ejected():
+		return "ejected"
+	}
+	return "active"
 }
 
 type WorkerStats struct {
-	SuccessfulRequests int    `json:"success_requests"`
-	FailedRequests     int    `json:"failed_requests"`
-	TotalRequests      int    `json:"total_requests"`
+	SuccessfulRequests int          `json:"success_requests"`
+	FailedRequests     int          `json:"failed_requests"`
+	TotalRequests      int          `json:"total_requests"`
+	Breaker            string       `json:"breaker,omitempty"`     // circuit breaker state as seen by the load balancer
+	Connections        int64        `json:"connections,omitempty"` // open requests and connections as seen by the load balancer
+	Draining           *DrainStatus `json:"draining,omitempty"`    // drain progress as seen by the load balancer
+}
+
+// Number of requests dispatched to the worker node that have not finished yet
+func (w *Worker) InFlight() int64 {
+	return w.inFlight.Load()
+}
+
+// Done marks a request handed out to the worker node as finished
+func (w *Worker) Done() {
+	w.inFlight.Add(-1)
+}
+
+// Records the latency of a response served by the worker node
+func (w *Worker) ObserveLatency(d time.Duration) {
+	w.latencyMux.Lock()
+	defer w.latencyMux.Unlock()
+
+	if w.latency == 0 {
+		w.latency = float64(d)
+		return
+	}
+	w.latency = latencyDecay*float64(d) + (1-latencyDecay)*w.latency
+}
+
+// Exponentially weighted moving average of the worker node's response latency
+func (w *Worker) Latency() time.Duration {
+	w.latencyMux.Lock()
+	defer w.latencyMux.Unlock()
+	return time.Duration(w.latency)
 }
 
-// Function to fetch worker stats from a given worker node
-func FetchWorkerStats(worker *Worker) WorkerStats {
-	resp, err := http.Get(worker.URL.String() + "/worker/stats")
+// Method to fetch worker stats from a given worker node of the pool
+func (lb *LoadBalancer) FetchWorkerStats(worker *Worker) WorkerStats {
+	// Workers that do not speak HTTP, such as those behind a TCP listener, must not hold up the stats
+	client := &http.Client{Transport: lb.Transport, Timeout: 5 * time.Second}
+	resp, err := client.Get(worker.URL.String() + "/worker/stats")
 	if err != nil {
-		LB.Logger.Printf("Error fetching stats from worker %s: %v", worker.URL.String(), err)
+		lb.Logger.Printf("Error fetching stats from worker %s: %v", worker.URL.String(), err)
 		return WorkerStats{}
 	}
 	defer resp.Body.Close()
 
 	var stats WorkerStats
 	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
-		LB.Logger.Printf("Error decoding stats from worker %s: %v", worker.URL.String(), err)
+		lb.Logger.Printf("Error decoding stats from worker %s: %v", worker.URL.String(), err)
 		return WorkerStats{}
 	}
 
@@ -0,0 +1,248 @@
+package certs
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store holds the certificates served by the HTTPS listener and picks one
+// for every handshake by the server name the client asked for. The files
+// are checked for changes in the background and reloaded without a restart.
+type Store struct {
+	files    []config.Certificate
+	logger   *log.Logger
+	mux      sync.RWMutex
+	exact    map[string]*tls.Certificate
+	wildcard map[string]*tls.Certificate // keyed by the suffix, e.g. ".example.internal"
+	fallback *tls.Certificate
+	stamps   []string // modification stamps of the files the certificates were loaded from
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// Function to load the certificates, fails if any of them is unusable
+func NewStore(logger *log.Logger, files []config.Certificate) (*Store, error) {
+	s := &Store{files: files, logger: logger, stop: make(chan struct{})}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetCertificate is the tls.Config callback selecting the certificate by SNI.
+// Exact names win over wildcards, clients without SNI get the first certificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.exact[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.wildcard[name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return s.fallback, nil
+}
+
+// Start checks the certificate files for changes on the given interval until Stop is called
+func (s *Store) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.reload()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background checks
+func (s *Store) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// Reloads the certificates if any of the files changed. A broken update keeps
+// the previous certificates in use and is retried on the next check.
+func (s *Store) reload() {
+	s.mux.RLock()
+	previous := s.stamps
+	s.mux.RUnlock()
+
+	stamps := s.modStamps()
+	if slices.Equal(stamps, previous) {
+		return
+	}
+	if err := s.load(); err != nil {
+		s.logger.Printf("Error reloading TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	s.logger.Println("TLS certificates reloaded")
+}
+
+// Reads every certificate and indexes it by the names it is valid for
+func (s *Store) load() error {
+	stamps := s.modStamps()
+	exact := make(map[string]*tls.Certificate)
+	wildcard := make(map[string]*tls.Certificate)
+	var fallback *tls.Certificate
+
+	for _, file := range s.files {
+		cert, err := tls.LoadX509KeyPair(file.Cert, file.Key)
+		if err != nil {
+			return fmt.Errorf("error loading certificate %s: %v", file.Cert, err)
+		}
+		if cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return fmt.Errorf("error parsing certificate %s: %v", file.Cert, err)
+			}
+		}
+		if fallback == nil {
+			fallback = &cert
+		}
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			// The first certificate listed for a name wins
+			if suffix, ok := strings.CutPrefix(name, "*"); ok {
+				if _, ok := wildcard[suffix]; !ok {
+					wildcard[suffix] = &cert
+				}
+			} else if _, ok := exact[name]; !ok {
+				exact[name] = &cert
+			}
+		}
+	}
+
+	s.mux.Lock()
+	s.exact, s.wildcard, s.fallback, s.stamps = exact, wildcard, fallback, stamps
+	s.mux.Unlock()
+	return nil
+}
+
+// Returns the modification time and size of every certificate and key file
+func (s *Store) modStamps() []string {
+	var stamps []string
+	for _, file := range s.files {
+		for _, path := range []string{file.Cert, file.Key} {
+			info, err := os.Stat(path)
+			if err != nil {
+				stamps = append(stamps, "missing")
+				continue
+			}
+			stamps = append(stamps, fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size()))
+		}
+	}
+	return stamps
+}
+
+// Function to build the TLS settings of the HTTPS listener
+func ServerConfig(cfg *config.TLS, store *Store) (*tls.Config, error) {
+	version, err := parseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := parseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	// HTTP/2 refuses a TLS 1.2 listener without one of its mandatory suites
+	if suites != nil && version < tls.VersionTLS13 && !slices.ContainsFunc(suites, http2Suite) {
+		return nil, fmt.Errorf("cipher_suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, HTTP/2 requires one of them")
+	}
+	return &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   suites,
+		GetCertificate: store.GetCertificate,
+	}, nil
+}
+
+// Function to parse a TLS version such as "1.2"
+func parseVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2", "":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version: %s", version)
+}
+
+// Function to look up cipher suites by their IANA names, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only suites Go considers secure are
+// accepted, nil leaves the choice to Go.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite: %s", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// Reports whether the cipher suite is one HTTP/2 requires for TLS 1.2
+func http2Suite(id uint16) bool {
+	return id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+}
+
+// Function to build the TLS settings used towards the worker nodes. caFile
+// replaces the system roots, certFile and keyFile are presented to workers
+// that ask for a client certificate, serverName overrides the verified name.
+func ClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle %s: %v", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate %s: %v", certFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
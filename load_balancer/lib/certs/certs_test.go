@@ -0,0 +1,43 @@
+package certs
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestServerConfigCipherSuites(t *testing.T) {
+	tests := []struct {
+		name       string
+		minVersion string
+		suites     []string
+		ok         bool
+	}{
+		{"go defaults", "1.2", nil, true},
+		{"rsa", "1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, true},
+		{"ecdsa", "1.2", []string{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, true},
+		{"missing http2 suite", "1.2", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, false},
+		{"tls 1.3 only", "1.3", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, true},
+		{"unknown suite", "1.2", []string{"TLS_RSA_WITH_NULL"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := ServerConfig(&config.TLS{MinVersion: tt.minVersion, CipherSuites: tt.suites}, &Store{})
+			if (err == nil) != tt.ok {
+				t.Fatalf("error %v, want ok %v", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+
+			// Whatever is accepted must also be accepted by the HTTP/2 server
+			server := &http.Server{TLSConfig: tlsConfig}
+			if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+				t.Errorf("HTTP/2 rejected the TLS settings: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the pool backed by available_nodes.txt and standby_nodes.txt
+const DefaultPool = "default"
+
+// Config is the load balancer configuration read from config.yaml
+type Config struct {
+	Pools       []Pool        `yaml:"pools"`
+	Routes      []Route       `yaml:"routes"` // routes of requests that match no virtual host
+	Hosts       []VirtualHost `yaml:"hosts"`
+	DefaultHost string        `yaml:"default_host"` // host serving requests that match no virtual host
+	TLS         *TLS          `yaml:"tls"`          // HTTPS listener, disabled if unset
+	TCP         []TCPListener `yaml:"tcp"`          // listeners relaying raw TCP connections
+	UDP         []UDPListener `yaml:"udp"`          // listeners balancing UDP datagrams
+}
+
+// TLS configures the HTTPS listener of the load balancer
+type TLS struct {
+	Listen         string        `yaml:"listen"`          // defaults to :2443
+	Certificates   []Certificate `yaml:"certificates"`    // picked by SNI, the first one is the fallback
+	MinVersion     string        `yaml:"min_version"`     // 1.0, 1.1, 1.2 or 1.3, defaults to 1.2
+	CipherSuites   []string      `yaml:"cipher_suites"`   // TLS 1.2 and older, defaults to Go's secure suites
+	ReloadInterval time.Duration `yaml:"reload_interval"` // how often the files are checked for changes, defaults to 10s
+}
+
+// Certificate is a PEM encoded certificate chain and its private key
+type Certificate struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// TCPListener accepts raw TCP connections and relays them to the worker nodes of a pool
+type TCPListener struct {
+	Listen         string        `yaml:"listen"`
+	Pool           string        `yaml:"pool"`            // defaults to the default pool
+	ConnectTimeout time.Duration `yaml:"connect_timeout"` // defaults to 5s
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`    // connections without traffic are closed, defaults to 5m
+}
+
+// UDPListener balances the datagrams it receives across the worker nodes of a
+// pool and sends the replies back to the clients
+type UDPListener struct {
+	Listen         string        `yaml:"listen"`
+	Pool           string        `yaml:"pool"`            // defaults to the default pool
+	Port           int           `yaml:"port"`            // port datagrams are sent to on the workers, defaults to the port of the pool
+	Affinity       bool          `yaml:"affinity"`        // keeps sending the datagrams of a client to the same worker
+	SessionTimeout time.Duration `yaml:"session_timeout"` // clients without traffic are forgotten, defaults to 30s
+}
+
+// VirtualHost routes the requests sent to a host name, either all of them to
+// Pool or through its own route table whose routes default to Pool.
+type VirtualHost struct {
+	Host   string  `yaml:"host"` // exact host name or a wildcard such as *.example.internal
+	Pool   string  `yaml:"pool"`
+	Routes []Route `yaml:"routes"`
+}
+
+// Pool is a named group of worker nodes behind the load balancer.
+// Settings left empty fall back to the values from the environment.
+type Pool struct {
+	Name           string        `yaml:"name"`
+	AvailableNodes string        `yaml:"available_nodes"` // defaults to <name>_available_nodes.txt
+	StandbyNodes   string        `yaml:"standby_nodes"`   // defaults to <name>_standby_nodes.txt
+	AllNodes       string        `yaml:"all_nodes"`       // defaults to <name>_all_nodes.txt
+	Strategy       string        `yaml:"strategy"`
+	HashKey        string        `yaml:"hash_key"`
+	HealthCheck    HealthCheck   `yaml:"health_check"`
+	Sticky         Sticky        `yaml:"sticky"`
+	UpstreamTLS    UpstreamTLS   `yaml:"upstream_tls"`
+	Protocol       string        `yaml:"upstream_protocol"` // auto, http1, h2 or h2c
+	Port           int           `yaml:"port"`              // port of worker nodes listed without one, defaults to 8080
+	MinWorkers     int           `yaml:"min_workers"`
+	MaxWorkers     int           `yaml:"max_workers"`
+	MaxConcurrent  int           `yaml:"max_concurrent"`
+	DrainTimeout   time.Duration `yaml:"drain_timeout"` // longest wait for a removed worker to finish its requests
+}
+
+// HealthCheck holds the active health check settings of a pool
+type HealthCheck struct {
+	Protocol           string        `yaml:"protocol"` // http, grpc or tcp
+	Service            string        `yaml:"service"`  // gRPC service to check, empty for the whole server
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	ExpectedStatus     int           `yaml:"expected_status"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+}
+
+// Sticky holds the session affinity settings of a pool
+type Sticky struct {
+	Cookie string        `yaml:"cookie"`
+	TTL    time.Duration `yaml:"ttl"`
+}
+
+// UpstreamTLS holds the settings of the TLS connections to the worker nodes of a pool
+type UpstreamTLS struct {
+	Enabled    *bool  `yaml:"enabled"`     // reach workers over https
+	CA         string `yaml:"ca"`          // CA bundle verifying the worker certificates
+	Cert       string `yaml:"cert"`        // client certificate for mutual TLS
+	Key        string `yaml:"key"`         // key of the client certificate
+	ServerName string `yaml:"server_name"` // name expected in the worker certificates
+}
+
+// Route maps requests to an upstream pool. Exactly one of Path, Prefix,
+// Regex or CatchAll selects the requests the route applies to.
+type Route struct {
+	Path        string  `yaml:"path"`         // exact request path
+	Prefix      string  `yaml:"prefix"`       // request path prefix
+	Regex       string  `yaml:"regex"`        // regular expression matched against the request path
+	CatchAll    bool    `yaml:"catch_all"`    // matches requests no other route matched
+	StripPrefix bool    `yaml:"strip_prefix"` // removes Prefix from the path before proxying
+	Pool        string  `yaml:"pool"`
+	Canary      *Canary `yaml:"canary"` // optional traffic split with a canary pool
+}
+
+// Canary sends a share of the clients of a route to a second pool
+type Canary struct {
+	Name   string `yaml:"name"` // identifies the split at runtime, defaults to the canary pool
+	Pool   string `yaml:"pool"`
+	Weight int    `yaml:"weight"` // percentage of clients sent to the canary pool
+	Header string `yaml:"header"` // requests carrying this header always go to the canary
+	Cookie string `yaml:"cookie"` // requests carrying this cookie always go to the canary
+}
+
+// Configuration used when there is no config file, it keeps the original
+// single route of the load balancer
+func Default() *Config {
+	config := &Config{
+		Routes: []Route{{Path: "/api/v1/hello", Pool: DefaultPool}},
+	}
+	config.normalize()
+	return config
+}
+
+// Function to read the configuration file, falling back to the default
+// configuration if the file does not exist
+func Load(filePath string) (*Config, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Default(), nil
+		}
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	var config Config
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode YAML: %v", err)
+	}
+
+	// The top-level routes are only used when no default host takes their place
+	if len(config.Routes) == 0 && config.DefaultHost == "" {
+		config.Routes = Default().Routes
+	}
+	if err := config.normalize(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Fills in the defaults of the pools and routes and makes sure the default pool exists
+func (c *Config) normalize() error {
+	seen := make(map[string]bool)
+	for i := range c.Pools {
+		pool := &c.Pools[i]
+		if pool.Name == "" {
+			return fmt.Errorf("pool %d has no name", i+1)
+		}
+		if seen[pool.Name] {
+			return fmt.Errorf("pool %s is defined twice", pool.Name)
+		}
+		seen[pool.Name] = true
+	}
+	if !seen[DefaultPool] {
+		c.Pools = append([]Pool{{Name: DefaultPool}}, c.Pools...)
+	}
+
+	for i := range c.Pools {
+		pool := &c.Pools[i]
+		// The default pool keeps the node files the load balancer always used
+		prefix := pool.Name + "_"
+		if pool.Name == DefaultPool {
+			prefix = ""
+		}
+		if pool.AvailableNodes == "" {
+			pool.AvailableNodes = prefix + "available_nodes.txt"
+		}
+		if pool.StandbyNodes == "" {
+			pool.StandbyNodes = prefix + "standby_nodes.txt"
+		}
+		if pool.AllNodes == "" {
+			pool.AllNodes = prefix + "all_nodes.txt"
+		}
+		if pool.Port < 0 || pool.Port > 65535 {
+			return fmt.Errorf("pool %s has an invalid port %d", pool.Name, pool.Port)
+		}
+	}
+
+	for i := range c.Routes {
+		if c.Routes[i].Pool == "" {
+			c.Routes[i].Pool = DefaultPool
+		}
+	}
+	hosts := make(map[string]bool)
+	for i := range c.Hosts {
+		host := &c.Hosts[i]
+		host.Host = strings.ToLower(host.Host)
+		if host.Host == "" {
+			return fmt.Errorf("virtual host %d has no host name", i+1)
+		}
+		if strings.Contains(host.Host, "*") && (!strings.HasPrefix(host.Host, "*.") || strings.Count(host.Host, "*") > 1) {
+			return fmt.Errorf("virtual host %s: wildcards are only allowed as the first label, e.g. *.example.internal", host.Host)
+		}
+		if hosts[host.Host] {
+			return fmt.Errorf("virtual host %s is defined twice", host.Host)
+		}
+		hosts[host.Host] = true
+
+		if host.Pool == "" {
+			host.Pool = DefaultPool
+		}
+		// A host without routes sends everything to its pool
+		if len(host.Routes) == 0 {
+			host.Routes = []Route{{CatchAll: true}}
+		}
+		for j := range host.Routes {
+			if host.Routes[j].Pool == "" {
+				host.Routes[j].Pool = host.Pool
+			}
+		}
+	}
+	for _, routes := range c.routeTables() {
+		for _, route := range routes {
+			if route.Canary != nil && route.Canary.Name == "" {
+				route.Canary.Name = route.Canary.Pool
+			}
+		}
+	}
+
+	c.DefaultHost = strings.ToLower(c.DefaultHost)
+	if c.DefaultHost != "" && !hosts[c.DefaultHost] {
+		return fmt.Errorf("default host %s is not one of the virtual hosts", c.DefaultHost)
+	}
+	if c.DefaultHost != "" && len(c.Routes) > 0 {
+		return fmt.Errorf("default host %s serves the requests for unknown hosts, remove the top-level routes or move them to a virtual host", c.DefaultHost)
+	}
+
+	if c.TLS != nil {
+		if len(c.TLS.Certificates) == 0 {
+			return fmt.Errorf("tls needs at least one certificate")
+		}
+		for i, cert := range c.TLS.Certificates {
+			if cert.Cert == "" || cert.Key == "" {
+				return fmt.Errorf("tls certificate %d needs both cert and key", i+1)
+			}
+		}
+		if c.TLS.Listen == "" {
+			c.TLS.Listen = ":2443"
+		}
+		if c.TLS.MinVersion == "" {
+			c.TLS.MinVersion = "1.2"
+		}
+		if c.TLS.ReloadInterval <= 0 {
+			c.TLS.ReloadInterval = 10 * time.Second
+		}
+	}
+
+	listens := make(map[string]bool)
+	for i := range c.TCP {
+		listener := &c.TCP[i]
+		if listener.Listen == "" {
+			return fmt.Errorf("tcp listener %d has no listen address", i+1)
+		}
+		if listens[listener.Listen] {
+			return fmt.Errorf("tcp listener %s is defined twice", listener.Listen)
+		}
+		listens[listener.Listen] = true
+
+		if listener.Pool == "" {
+			listener.Pool = DefaultPool
+		}
+		if !seen[listener.Pool] && listener.Pool != DefaultPool {
+			return fmt.Errorf("tcp listener %s: unknown pool %s", listener.Listen, listener.Pool)
+		}
+		if listener.ConnectTimeout <= 0 {
+			listener.ConnectTimeout = 5 * time.Second
+		}
+		if listener.IdleTimeout <= 0 {
+			listener.IdleTimeout = 5 * time.Minute
+		}
+	}
+
+	listens = make(map[string]bool)
+	for i := range c.UDP {
+		listener := &c.UDP[i]
+		if listener.Listen == "" {
+			return fmt.Errorf("udp listener %d has no listen address", i+1)
+		}
+		if listens[listener.Listen] {
+			return fmt.Errorf("udp listener %s is defined twice", listener.Listen)
+		}
+		listens[listener.Listen] = true
+
+		if listener.Pool == "" {
+			listener.Pool = DefaultPool
+		}
+		if !seen[listener.Pool] && listener.Pool != DefaultPool {
+			return fmt.Errorf("udp listener %s: unknown pool %s", listener.Listen, listener.Pool)
+		}
+		if listener.Port < 0 || listener.Port > 65535 {
+			return fmt.Errorf("udp listener %s has an invalid port %d", listener.Listen, listener.Port)
+		}
+		if listener.SessionTimeout <= 0 {
+			listener.SessionTimeout = 30 * time.Second
+		}
+	}
+	return nil
+}
+
+// Returns the top-level route table followed by the route tables of the virtual hosts
+func (c *Config) routeTables() [][]Route {
+	tables := [][]Route{c.Routes}
+	for _, host := range c.Hosts {
+		tables = append(tables, host.Routes)
+	}
+	return tables
+}
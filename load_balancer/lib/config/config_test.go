@@ -0,0 +1,46 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefaultHost(t *testing.T) {
+	hosts := "hosts:\n  - host: api.example.internal\n"
+	tests := []struct {
+		name   string
+		yaml   string
+		err    string // part of the expected error, empty if the file is valid
+		routes int    // top-level routes after loading
+	}{
+		{"routes only", "routes:\n  - prefix: /\n", "", 1},
+		{"no routes", "pools:\n  - name: default\n", "", 1}, // the original single route
+		{"default host", hosts + "default_host: api.example.internal\n", "", 0},
+		{"default host and routes", hosts + "default_host: api.example.internal\nroutes:\n  - prefix: /\n", "remove the top-level routes", 0},
+		{"unknown default host", hosts + "default_host: www.example.internal\n", "not one of the virtual hosts", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Routes) != tt.routes {
+				t.Errorf("%d top-level routes, want %d", len(cfg.Routes), tt.routes)
+			}
+		})
+	}
+}
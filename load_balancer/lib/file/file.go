@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 )
 
+// NodesMux guards the node files, which the scaling middleware and the admin API both rewrite
+var NodesMux sync.Mutex
+
 // Reads the first line from a file and removes it from the file
 func ReadFirstLineAndRemove(filename string) (string, error) {
 	return readLineAndRemove(filename, true)
@@ -68,6 +72,32 @@ func readLineAndRemove(filename string, first bool) (string, error) {
 	return strings.TrimSpace(line), nil
 }
 
+// Removes the first line the match function accepts and returns it, an empty
+// string if no line matched
+func RemoveLine(filename string, match func(line string) bool) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || !match(strings.TrimSpace(line)) {
+			continue
+		}
+		lines = append(lines[:i], lines[i+1:]...)
+		content := strings.Join(lines, "\n")
+		if content != "" {
+			content += "\n"
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+	return "", nil
+}
+
 // Appends a line to a file
 func AppendToFile(filename, line string) error {
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
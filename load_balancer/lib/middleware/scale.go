@@ -3,190 +3,217 @@ package middleware
 import (
 	"GoBalance/loadbalancer/lb"
 	"GoBalance/loadbalancer/lib/file"
-	"log"
+	"bufio"
+	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"strings"
 	"sync"
-
-	"github.com/joho/godotenv"
 )
 
-var (
-	maxConcurrentRequests int64
-	minPoolSize           int64
-	maxPoolSize           int64
-	limiter               chan struct{}
-	mu                    sync.Mutex
-	currentRequests       int64
-	fileMutex             sync.Mutex
-)
+// Scaling state of a single pool, shared by its HTTP routes and TCP listeners
+type Scaler struct {
+	pool            *lb.LoadBalancer
+	limiter         chan struct{}
+	mu              sync.Mutex
+	currentRequests int64
+}
 
-func init() {
-	err := godotenv.Load("./.env")
-	if err != nil {
-		log.Println("Error loading environment\nContinuing anyway..")
+// Function to create the scaling state of a pool
+func NewScaler(pool *lb.LoadBalancer) *Scaler {
+	return &Scaler{
+		pool:    pool,
+		limiter: make(chan struct{}, pool.Scaling.MaxConcurrent),
 	}
-	poolEnv := os.Getenv("POOL")
-	if poolEnv == "" {
-		maxConcurrentRequests = 20
-	} else {
-		parsed, err := strconv.ParseInt(poolEnv, 10, 64)
-		if err != nil {
-			log.Printf("Error parsing POOL environment variable: %v. Using default value of 20.", err)
-			maxConcurrentRequests = 20
-		} else {
-			maxConcurrentRequests = parsed
-		}
-	}
-	limiter = make(chan struct{}, maxConcurrentRequests)
-	log.Printf("Max concurrent requests is set to: %d", maxConcurrentRequests)
+}
 
-	minPoolEnv := os.Getenv("WORKER")
-	if minPoolEnv == "" {
-		minPoolSize = 2
-	} else {
-		parsed, err := strconv.ParseInt(minPoolEnv, 10, 64)
-		if err != nil {
-			log.Printf("Error parsing WORKER environment variable: %v. Using default value of 2.", err)
-			minPoolSize = 2
-		} else {
-			minPoolSize = parsed
+// Middleware that handles scaling of the pool based on the number of requests
+func ScalingMiddleware(s *Scaler, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Acquire() {
+			// Too many requests, return 429 error
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
 		}
-	}
-	log.Printf("Min pool size set to: %d", minPoolSize)
-
-	maxPoolEnv := os.Getenv("MAX_WORKER")
-	if maxPoolEnv == "" {
-		maxPoolSize = minPoolSize
-	} else {
-		parsed, err := strconv.ParseInt(maxPoolEnv, 10, 64)
-		if err != nil {
-			log.Printf("Error parsing WORKER environment variable: %v. Using default value of 2.", err)
-			maxPoolSize = minPoolSize
-		} else {
-			maxPoolSize = parsed
+		var once sync.Once
+		release := func() { once.Do(s.Release) }
+		defer release()
+
+		// Upgraded connections only hold their slot during the handshake, once
+		// the connection is taken over it counts toward the load of its worker
+		if lb.IsUpgrade(r) {
+			w = &upgradeWriter{ResponseWriter: w, release: release}
 		}
+		next.ServeHTTP(w, r)
 	}
-	log.Printf("Max pool size set to: %d", maxPoolSize)
 }
 
-// Middleware that handles scaling based on the number of requests
-func ScalingMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		select {
-		case limiter <- struct{}{}:
-			updateActiveRequests(1)
-
-			// Check for scale-up logic
-			checkScaleUp()
+// Response writer of an upgrade request, releases the admission slot when the
+// client connection is hijacked
+type upgradeWriter struct {
+	http.ResponseWriter
+	release func()
+}
 
-			next.ServeHTTP(w, r)
-			defer func() {
-				<-limiter
-				updateActiveRequests(-1)
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.release()
+	}
+	return conn, brw, err
+}
 
-				// Check for scale-down logic
-				checkScaleDown()
-			}()
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
 
-		default:
-			// Too many requests, return 429 error
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-		}
+// Method to admit a request or connection, false if the pool is at its limit.
+// Every admitted request or connection must be released once it has finished.
+func (s *Scaler) Acquire() bool {
+	select {
+	case s.limiter <- struct{}{}:
+		s.updateActiveRequests(1)
+
+		// Check for scale-up logic
+		s.checkScaleUp()
+		return true
+	default:
+		return false
 	}
 }
 
+// Method to release an admitted request or connection
+func (s *Scaler) Release() {
+	<-s.limiter
+	s.updateActiveRequests(-1)
+
+	// Check for scale-down logic
+	s.checkScaleDown()
+}
+
 // Function to update the active request count
-func updateActiveRequests(delta int64) {
-	mu.Lock()
-	defer mu.Unlock()
-	currentRequests += delta
+func (s *Scaler) updateActiveRequests(delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentRequests += delta
 }
 
 // Function to check if we need to scale up
-func checkScaleUp() {
-	mu.Lock()
-	defer mu.Unlock()
+func (s *Scaler) checkScaleUp() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pool.Stopping() {
+		return
+	}
 
-	halfMax := maxConcurrentRequests / 2
-	if currentRequests >= halfMax && int64(len(lb.LB.Workers)) < maxPoolSize {
-		lb.LB.Logger.Printf("Scaling up, active requests: %d, current workers: %d", currentRequests, len(lb.LB.Workers))
-		scaleUp()
+	halfMax := s.pool.Scaling.MaxConcurrent / 2
+	if s.currentRequests >= halfMax && int64(s.pool.ActiveWorkers()) < s.pool.Scaling.MaxWorkers {
+		s.pool.Logger.Printf("Scaling up, active requests: %d, current workers: %d", s.currentRequests, s.pool.ActiveWorkers())
+		s.scaleUp()
 	}
 }
 
 // Function to check if we need to scale down
-func checkScaleDown() {
-	mu.Lock()
-	defer mu.Unlock()
+func (s *Scaler) checkScaleDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pool.Stopping() {
+		return
+	}
 
-	halfMax := maxConcurrentRequests / 2
-	if currentRequests <= halfMax && int64(len(lb.LB.Workers)) > minPoolSize {
-		lb.LB.Logger.Printf("Scaling down, active requests: %d, current workers: %d", currentRequests, len(lb.LB.Workers))
-		scaleDown()
+	halfMax := s.pool.Scaling.MaxConcurrent / 2
+	if s.currentRequests <= halfMax && int64(s.pool.ActiveWorkers()) > s.pool.Scaling.MinWorkers {
+		s.pool.Logger.Printf("Scaling down, active requests: %d, current workers: %d", s.currentRequests, s.pool.ActiveWorkers())
+		s.scaleDown()
 	}
 }
 
 // Function to add worker nodes from the pool of standy workers
-func scaleUp() {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+func (s *Scaler) scaleUp() {
+	file.NodesMux.Lock()
+	defer file.NodesMux.Unlock()
 
-	ip, err := file.ReadFirstLineAndRemove("standby_nodes.txt")
+	pool := s.pool
+	ip, err := file.ReadFirstLineAndRemove(pool.StandbyFile)
 	if err != nil {
-		lb.LB.Logger.Printf("Error reading from standby_nodes.txt: %v", err)
+		pool.Logger.Printf("Error reading from %s: %v", pool.StandbyFile, err)
 		return
 	}
 
 	if ip == "" {
-		lb.LB.Logger.Println("No standby nodes available for scaling up.")
+		pool.Logger.Println("No standby nodes available for scaling up.")
 		return
 	}
 
 	go func() {
-		err := lb.LB.AddWorker(ip)
+		file.NodesMux.Lock()
+		defer file.NodesMux.Unlock()
+
+		err := pool.AddWorker(ip)
 		if err != nil {
-			lb.LB.Logger.Printf("Error adding worker %s: %v", ip, err)
+			pool.Logger.Printf("Error adding worker %s: %v", ip, err)
 			// If failed to add, put it back in standby
-			file.AppendToFile("standby_nodes.txt", ip)
+			file.AppendToFile(pool.StandbyFile, ip)
 		} else {
-			lb.LB.Logger.Printf("Successfully scaled up. Added worker: %s", ip)
-			file.AppendToFile("available_nodes.txt", ip)
+			pool.Logger.Printf("Successfully scaled up. Added worker: %s", ip)
+			file.AppendToFile(pool.AvailableFile, ip)
 		}
 	}()
 }
 
 // Function to remove worker nodes from the pool of available nodes
-func scaleDown() {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+func (s *Scaler) scaleDown() {
+	file.NodesMux.Lock()
+	defer file.NodesMux.Unlock()
 
-	if int64(len(lb.LB.Workers)) <= minPoolSize {
-		lb.LB.Logger.Printf("Cannot scale down. Current workers (%d) at or below min pool size (%d)", len(lb.LB.Workers), minPoolSize)
+	pool := s.pool
+	if int64(pool.ActiveWorkers()) <= pool.Scaling.MinWorkers {
+		pool.Logger.Printf("Cannot scale down. Current workers (%d) at or below min pool size (%d)", pool.ActiveWorkers(), pool.Scaling.MinWorkers)
 		return
 	}
 
-	ip, err := file.ReadLastLineAndRemove("available_nodes.txt")
+	lines, err := file.ReadIPAddresses(pool.AvailableFile)
 	if err != nil {
-		lb.LB.Logger.Printf("Error reading from available_nodes.txt: %v", err)
+		pool.Logger.Printf("Error reading from %s: %v", pool.AvailableFile, err)
 		return
 	}
 
+	// The last listed worker still taking traffic goes, workers drained or
+	// disabled through the admin API are left alone
+	ip := ""
+	for i := len(lines) - 1; i >= 0 && ip == ""; i-- {
+		line := strings.TrimSpace(lines[i])
+		if worker := pool.FindWorker(line); line != "" && worker != nil && worker.Accepting() {
+			ip = line
+		}
+	}
 	if ip == "" {
-		lb.LB.Logger.Println("No available nodes to scale down.")
+		pool.Logger.Println("No available nodes to scale down.")
 		return
 	}
 
+	// Let the worker finish its requests before it goes to standby. It is marked
+	// as draining while the node files are locked, so a reload of the node files
+	// does not take it for a worker removed by hand.
+	wait, err := pool.StartDrain(ip)
+	if err != nil {
+		pool.Logger.Printf("Error removing worker %s: %v", ip, err)
+		return
+	}
+	if _, err := file.RemoveLine(pool.AvailableFile, func(line string) bool { return line == ip }); err != nil {
+		pool.Logger.Printf("Error updating %s: %v", pool.AvailableFile, err)
+	}
 	go func() {
-		err := lb.LB.RemoveWorker(ip)
+		err := wait()
+		file.NodesMux.Lock()
+		defer file.NodesMux.Unlock()
+
 		if err != nil {
-			lb.LB.Logger.Printf("Error removing worker %s: %v", ip, err)
+			pool.Logger.Printf("Error removing worker %s: %v", ip, err)
 			// If failed to remove, put it back in available
-			file.AppendToFile("available_nodes.txt", ip)
+			file.AppendToFile(pool.AvailableFile, ip)
 		} else {
-			lb.LB.Logger.Printf("Successfully scaled down. Removed worker: %s", ip)
-			file.AppendToFile("standby_nodes.txt", ip)
+			pool.Logger.Printf("Successfully scaled down. Removed worker: %s", ip)
+			file.AppendToFile(pool.StandbyFile, ip)
 		}
 	}()
 }
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"GoBalance/loadbalancer/lb"
+	"GoBalance/loadbalancer/lib/file"
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScalingMiddlewareUpgrades(t *testing.T) {
+	pool := lb.NewLoadBalancer(log.New(io.Discard, "", 0), nil)
+	pool.Scaling = lb.ScalingConfig{MaxConcurrent: 1}
+	scaler := NewScaler(pool)
+
+	// Upgrades are taken over and held open, like a relayed WebSocket
+	hold := make(chan struct{})
+	defer close(hold)
+	server := httptest.NewServer(ScalingMiddleware(scaler, func(w http.ResponseWriter, r *http.Request) {
+		if !lb.IsUpgrade(r) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijacking the upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		<-hold
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: lb\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade got status %d", resp.StatusCode)
+	}
+
+	// The open connection no longer holds the only slot
+	plain, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Body.Close()
+	if plain.StatusCode != http.StatusOK {
+		t.Errorf("plain request next to an open upgrade got status %d", plain.StatusCode)
+	}
+
+	// A request that is still running does hold it
+	scaler.Acquire()
+	defer scaler.Release()
+	busy, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.Body.Close()
+	if busy.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("request over the limit got status %d, want 429", busy.StatusCode)
+	}
+}
+
+func TestScaleDownSkipsDrainingWorkers(t *testing.T) {
+	dir := t.TempDir()
+	pool := lb.NewLoadBalancer(log.New(io.Discard, "", 0), nil)
+	pool.Scaling = lb.ScalingConfig{MaxConcurrent: 10, MinWorkers: 1, MaxWorkers: 4}
+	pool.AvailableFile = filepath.Join(dir, "available_nodes.txt")
+	pool.StandbyFile = filepath.Join(dir, "standby_nodes.txt")
+	nodes := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3 weight=2", "10.0.0.4"}
+	for _, node := range nodes {
+		if err := pool.AddWorker(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(pool.AvailableFile, []byte(strings.Join(nodes, "\n")+"\n"), 0644)
+	os.WriteFile(pool.StandbyFile, nil, 0644)
+
+	// The last worker is being drained through the admin API, the one before is disabled.
+	// The drain is never waited for, so the worker stays draining.
+	if _, err := pool.StartDrain("10.0.0.4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.DisableNode("10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	scaler := NewScaler(pool)
+	scaler.scaleDown()
+
+	if state := pool.FindWorker("10.0.0.2").State(); state != "draining" {
+		t.Errorf("worker 10.0.0.2 is %s, want it scaled down", state)
+	}
+	if state := pool.FindWorker("10.0.0.3 weight=2").State(); state != "disabled" {
+		t.Errorf("disabled worker 10.0.0.3 is %s", state)
+	}
+	data, _ := os.ReadFile(pool.AvailableFile)
+	if want := "10.0.0.1\n10.0.0.3 weight=2\n10.0.0.4\n"; string(data) != want {
+		t.Errorf("available nodes %q, want %q", data, want)
+	}
+
+	// Once it is drained the scaled down worker goes to standby
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		file.NodesMux.Lock()
+		data, _ = os.ReadFile(pool.StandbyFile)
+		file.NodesMux.Unlock()
+		if len(data) > 0 {
+			break
+		}
+	}
+	if pool.FindWorker("10.0.0.2") != nil || string(data) != "10.0.0.2\n" {
+		t.Errorf("standby nodes %q, want the scaled down worker", data)
+	}
+}
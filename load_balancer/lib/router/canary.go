@@ -0,0 +1,81 @@
+package router
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Canary splits the traffic of a route between its pool and a canary pool.
+// Clients are bucketed by a hash of their IP address, so a client keeps
+// landing on the same side, and raising the weight only moves clients over
+// to the canary. Requests carrying the canary header or cookie always go to
+// the canary pool.
+type Canary struct {
+	Name    string
+	Pool    string
+	Canary  string
+	header  string
+	cookie  string
+	weight  atomic.Int32 // percentage of clients sent to the canary pool
+	initial int          // weight given in the configuration
+	handler http.Handler
+}
+
+func newCanary(pool string, cfg *config.Canary, handler http.Handler) (*Canary, error) {
+	if cfg.Weight < 0 || cfg.Weight > 100 {
+		return nil, fmt.Errorf("canary %s has weight %d, it must be between 0 and 100", cfg.Name, cfg.Weight)
+	}
+	c := &Canary{
+		Name:    cfg.Name,
+		Pool:    pool,
+		Canary:  cfg.Pool,
+		header:  cfg.Header,
+		cookie:  cfg.Cookie,
+		initial: cfg.Weight,
+		handler: handler,
+	}
+	c.weight.Store(int32(cfg.Weight))
+	return c, nil
+}
+
+// Current percentage of clients sent to the canary pool
+func (c *Canary) Weight() int {
+	return int(c.weight.Load())
+}
+
+// Changes the percentage of clients sent to the canary pool
+func (c *Canary) SetWeight(weight int) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("weight must be between 0 and 100")
+	}
+	c.weight.Store(int32(weight))
+	return nil
+}
+
+// Decides whether the request goes to the canary pool
+func (c *Canary) pick(r *http.Request) bool {
+	if c.header != "" && r.Header.Get(c.header) != "" {
+		return true
+	}
+	if c.cookie != "" {
+		if cookie, err := r.Cookie(c.cookie); err == nil && cookie.Value != "" {
+			return true
+		}
+	}
+
+	weight := c.weight.Load()
+	if weight <= 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	h := fnv.New32a()
+	h.Write([]byte(c.Name + "/" + host))
+	return int32(h.Sum32()%100) < weight
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// HostRouter picks the route table by the Host header of the request.
+// Exact host names take precedence over wildcards, and among wildcards the
+// longest suffix wins. Requests for unknown hosts go to the default host, or
+// to the top-level route table if no default host is configured.
+type HostRouter struct {
+	exact     map[string]*Router
+	wildcards map[string]*Router // keyed by the suffix, e.g. ".example.internal"
+	fallback  *Router
+	canaries  map[string]*Canary
+}
+
+// Function to build the host router from the configuration.
+// target returns the handler proxying to the named pool.
+func NewHostRouter(cfg *config.Config, target func(pool string) (http.Handler, error)) (*HostRouter, error) {
+	hr := &HostRouter{
+		exact:     make(map[string]*Router),
+		wildcards: make(map[string]*Router),
+		canaries:  make(map[string]*Canary),
+	}
+
+	for _, host := range cfg.Hosts {
+		routes, err := New(host.Routes, target)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %v", host.Host, err)
+		}
+		if err := hr.addCanaries(routes); err != nil {
+			return nil, err
+		}
+		if suffix, ok := strings.CutPrefix(host.Host, "*"); ok {
+			hr.wildcards[suffix] = routes
+		} else {
+			hr.exact[host.Host] = routes
+		}
+	}
+
+	if cfg.DefaultHost != "" {
+		hr.fallback = hr.lookup(cfg.DefaultHost)
+	} else {
+		routes, err := New(cfg.Routes, target)
+		if err != nil {
+			return nil, err
+		}
+		if err := hr.addCanaries(routes); err != nil {
+			return nil, err
+		}
+		hr.fallback = routes
+	}
+	return hr, nil
+}
+
+// Registers the canaries of a route table, their names must be unique
+func (hr *HostRouter) addCanaries(routes *Router) error {
+	for _, canary := range routes.canaries {
+		if _, ok := hr.canaries[canary.Name]; ok {
+			return fmt.Errorf("canary %s is defined twice, give the canaries distinct names", canary.Name)
+		}
+		hr.canaries[canary.Name] = canary
+	}
+	return nil
+}
+
+// Returns the canary with the given name, nil if there is none
+func (hr *HostRouter) Canary(name string) *Canary {
+	return hr.canaries[name]
+}
+
+// Returns all canaries ordered by name
+func (hr *HostRouter) Canaries() []*Canary {
+	canaries := make([]*Canary, 0, len(hr.canaries))
+	for _, canary := range hr.canaries {
+		canaries = append(canaries, canary)
+	}
+	sort.Slice(canaries, func(i, j int) bool { return canaries[i].Name < canaries[j].Name })
+	return canaries
+}
+
+// Returns the route table of the host, nil if no virtual host matches
+func (hr *HostRouter) lookup(host string) *Router {
+	if routes, ok := hr.exact[host]; ok {
+		return routes
+	}
+
+	var matched *Router
+	longest := 0
+	for suffix, routes := range hr.wildcards {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) && len(suffix) > longest {
+			matched = routes
+			longest = len(suffix)
+		}
+	}
+	return matched
+}
+
+func (hr *HostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	routes := hr.lookup(strings.TrimSuffix(strings.ToLower(host), "."))
+	if routes == nil {
+		routes = hr.fallback
+	}
+	routes.ServeHTTP(w, r)
+}
+
+// Routes serves requests with the host router in use, which is replaced when
+// the configuration is reloaded
+type Routes struct {
+	current atomic.Pointer[HostRouter]
+}
+
+func NewRoutes(hr *HostRouter) *Routes {
+	routes := &Routes{}
+	routes.current.Store(hr)
+	return routes
+}
+
+// Swap puts a new host router in use, requests in progress finish on the
+// previous one. Canaries keep the weight they were given at runtime unless
+// their weight in the configuration changed.
+func (rs *Routes) Swap(hr *HostRouter) {
+	previous := rs.current.Load()
+	for name, canary := range hr.canaries {
+		if old, ok := previous.canaries[name]; ok && old.initial == canary.initial {
+			canary.weight.Store(old.weight.Load())
+		}
+	}
+	rs.current.Store(hr)
+}
+
+// Returns the canary with the given name in the host router in use, nil if there is none
+func (rs *Routes) Canary(name string) *Canary {
+	return rs.current.Load().Canary(name)
+}
+
+// Returns all canaries of the host router in use ordered by name
+func (rs *Routes) Canaries() []*Canary {
+	return rs.current.Load().Canaries()
+}
+
+func (rs *Routes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.current.Load().ServeHTTP(w, r)
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"net/http"
+	"testing"
+)
+
+// Function to build a host router with one route split with the canary v2
+func canaryRouter(t *testing.T, weight int) *HostRouter {
+	t.Helper()
+	cfg := &config.Config{Routes: []config.Route{{
+		Prefix: "/",
+		Pool:   "default",
+		Canary: &config.Canary{Name: "v2", Pool: "images", Weight: weight},
+	}}}
+	hr, err := NewHostRouter(cfg, func(pool string) (http.Handler, error) { return http.NotFoundHandler(), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hr
+}
+
+func TestSwapKeepsCanaryWeights(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured int // weight in the reloaded configuration
+		want       int
+	}{
+		{"configured weight unchanged", 10, 50},
+		{"configured weight changed", 20, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := NewRoutes(canaryRouter(t, 10))
+			if err := routes.Canary("v2").SetWeight(50); err != nil {
+				t.Fatal(err)
+			}
+
+			routes.Swap(canaryRouter(t, tt.configured))
+			if weight := routes.Canary("v2").Weight(); weight != tt.want {
+				t.Errorf("weight %d after the reload, want %d", weight, tt.want)
+			}
+		})
+	}
+}
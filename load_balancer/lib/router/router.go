@@ -0,0 +1,136 @@
+package router
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A compiled route of the route table
+type route struct {
+	config.Route
+	regex   *regexp.Regexp
+	handler http.Handler
+	canary  *Canary
+}
+
+// Router dispatches requests to upstream pools according to the route table.
+// Routes are tried in the order they are configured and the first match wins,
+// the catch-all route handles whatever no other route matched.
+type Router struct {
+	routes   []*route
+	catchAll *route
+	canaries []*Canary
+}
+
+// Function to build a router from the configured routes.
+// target returns the handler proxying to the named pool.
+func New(routes []config.Route, target func(pool string) (http.Handler, error)) (*Router, error) {
+	router := &Router{}
+	for i, cfg := range routes {
+		r := &route{Route: cfg}
+
+		matchers := 0
+		for _, set := range []bool{cfg.Path != "", cfg.Prefix != "", cfg.Regex != "", cfg.CatchAll} {
+			if set {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return nil, fmt.Errorf("route %d must set exactly one of path, prefix, regex or catch_all", i+1)
+		}
+		if cfg.StripPrefix && cfg.Prefix == "" {
+			return nil, fmt.Errorf("route %d sets strip_prefix without a prefix", i+1)
+		}
+
+		if cfg.Regex != "" {
+			regex, err := regexp.Compile(cfg.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("route %d has an invalid regex: %v", i+1, err)
+			}
+			r.regex = regex
+		}
+
+		handler, err := target(cfg.Pool)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", i+1, err)
+		}
+		r.handler = handler
+
+		if cfg.Canary != nil {
+			if cfg.Canary.Pool == "" {
+				return nil, fmt.Errorf("route %d: canary needs a pool", i+1)
+			}
+			canaryHandler, err := target(cfg.Canary.Pool)
+			if err != nil {
+				return nil, fmt.Errorf("route %d canary: %v", i+1, err)
+			}
+			r.canary, err = newCanary(cfg.Pool, cfg.Canary, canaryHandler)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %v", i+1, err)
+			}
+			router.canaries = append(router.canaries, r.canary)
+		}
+
+		if cfg.CatchAll {
+			if router.catchAll != nil {
+				return nil, fmt.Errorf("route %d is a second catch-all route", i+1)
+			}
+			router.catchAll = r
+			continue
+		}
+		router.routes = append(router.routes, r)
+	}
+	return router, nil
+}
+
+// Reports whether the route applies to the request path
+func (r *route) matches(path string) bool {
+	switch {
+	case r.Path != "":
+		return path == r.Path
+	case r.Prefix != "":
+		// Prefixes match whole path segments, /api does not match /apiv2
+		return path == r.Prefix || strings.HasPrefix(path, strings.TrimSuffix(r.Prefix, "/")+"/")
+	case r.regex != nil:
+		return r.regex.MatchString(path)
+	}
+	return false
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	matched := rt.catchAll
+	for _, route := range rt.routes {
+		if route.matches(r.URL.Path) {
+			matched = route
+			break
+		}
+	}
+	if matched == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if matched.StripPrefix {
+		r = stripPrefix(r, matched.Prefix)
+	}
+	if matched.canary != nil && matched.canary.pick(r) {
+		matched.canary.handler.ServeHTTP(w, r)
+		return
+	}
+	matched.handler.ServeHTTP(w, r)
+}
+
+// Returns a copy of the request with the prefix removed from its path
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	r2.URL.RawPath = ""
+	return r2
+}
@@ -0,0 +1,52 @@
+package router
+
+import (
+	"GoBalance/loadbalancer/lib/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefixRoutes(t *testing.T) {
+	tests := []struct {
+		prefix string
+		strip  bool
+		path   string
+		want   string // pool and path the request reaches
+	}{
+		{"/api", false, "/api", "api /api"},
+		{"/api", false, "/api/users", "api /api/users"},
+		{"/api", false, "/apiv2/users", "default /apiv2/users"},
+		{"/api/", false, "/api/users", "api /api/users"},
+		{"/api/", false, "/apiv2", "default /apiv2"},
+		{"/api", true, "/api", "api /"},
+		{"/api", true, "/api/users", "api /users"},
+		{"/api", true, "/apiv2/users", "default /apiv2/users"},
+		{"/api/v2/", true, "/api/v2/users", "api /users"},
+		{"/", false, "/anything", "api /anything"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+" "+tt.path, func(t *testing.T) {
+			target := func(pool string) (http.Handler, error) {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.WriteString(w, pool+" "+r.URL.Path)
+				}), nil
+			}
+			router, err := New([]config.Route{
+				{Prefix: tt.prefix, StripPrefix: tt.strip, Pool: "api"},
+				{CatchAll: true, Pool: "default"},
+			}, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("request reached %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
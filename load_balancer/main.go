@@ -3,17 +3,41 @@ package main
 import (
 	"GoBalance/loadbalancer/controllers"
 	"GoBalance/loadbalancer/lb"
+	"GoBalance/loadbalancer/lib/certs"
+	"GoBalance/loadbalancer/lib/config"
 	"GoBalance/loadbalancer/lib/middleware"
+	"GoBalance/loadbalancer/lib/router"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
+var cfg *config.Config
+
 // Initialize the load balancer
 func init() {
-	retries := 2
 	var err error
+	cfg, err = config.Load("config.yaml")
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+
+	retries := 2
 	for i := 0; i < retries; i++ {
-		err = lb.Init()
+		err = lb.Init(cfg)
 		if err == nil {
 			break
 		}
@@ -29,14 +53,199 @@ func main() {
 		log.Println("Unable to intialize the load balancer")
 		return
 	}
+
+	// Every pool gets a single scaler shared by its routes and TCP listeners
+	scalers := make(map[string]*middleware.Scaler)
+	scaler := func(pool *lb.LoadBalancer) *middleware.Scaler {
+		if _, ok := scalers[pool.Name]; !ok {
+			scalers[pool.Name] = middleware.NewScaler(pool)
+		}
+		return scalers[pool.Name]
+	}
+
+	// Build the route tables, every pool gets a single scaling handler shared by its routes
+	handlers := make(map[string]http.Handler)
+	target := func(name string) (http.Handler, error) {
+		if handler, ok := handlers[name]; ok {
+			return handler, nil
+		}
+		pool := lb.GetPool(name)
+		if pool == nil {
+			return nil, fmt.Errorf("unknown pool: %s", name)
+		}
+		handlers[name] = middleware.ScalingMiddleware(scaler(pool), controllers.Proxy(pool))
+		return handlers[name], nil
+	}
+	hosts, err := router.NewHostRouter(cfg, target)
+	if err != nil {
+		lb.LB.Logger.Fatal("Error building route table: ", err)
+	}
+	routes := router.NewRoutes(hosts)
+
 	// Setup the routes with middleware
-	http.HandleFunc("/api/v1/hello", middleware.ScalingMiddleware(controllers.Hello))
-	http.HandleFunc("/worker/stats", controllers.Stats)
+	mux := http.NewServeMux()
+	mux.Handle("/", routes)
+	mux.HandleFunc("/worker/stats", controllers.Stats)
+	mux.HandleFunc("GET /admin/canaries", middleware.AdminAuth(controllers.Canaries(routes)))
+	mux.HandleFunc("POST /admin/canaries", middleware.AdminAuth(controllers.Canaries(routes)))
+	mux.HandleFunc("GET /admin/workers", middleware.AdminAuth(controllers.Workers))
+	mux.HandleFunc("POST /admin/workers", middleware.AdminAuth(controllers.Workers))
+	mux.HandleFunc("DELETE /admin/workers", middleware.AdminAuth(controllers.Workers))
+	mux.HandleFunc("POST /admin/workers/drain", middleware.AdminAuth(controllers.WorkerAction("drain")))
+	mux.HandleFunc("POST /admin/workers/enable", middleware.AdminAuth(controllers.WorkerAction("enable")))
+	mux.HandleFunc("POST /admin/workers/disable", middleware.AdminAuth(controllers.WorkerAction("disable")))
+
+	// Everything that has to be stopped on shutdown
+	var servers []*http.Server
+	var listeners []io.Closer
+	var store *certs.Store
+
+	// Terminate TLS on the HTTPS listener if it is configured
+	if cfg.TLS != nil {
+		store, err = certs.NewStore(lb.LB.Logger, cfg.TLS.Certificates)
+		if err != nil {
+			lb.LB.Logger.Fatal("Error loading TLS certificates: ", err)
+		}
+		tlsConfig, err := certs.ServerConfig(cfg.TLS, store)
+		if err != nil {
+			lb.LB.Logger.Fatal("Error configuring TLS: ", err)
+		}
+		store.Start(cfg.TLS.ReloadInterval)
+
+		// Clients negotiate HTTP/2 or HTTP/1.1 through ALPN
+		server := &http.Server{Addr: cfg.TLS.Listen, Handler: mux, TLSConfig: tlsConfig}
+		if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+			lb.LB.Logger.Fatal("Error configuring HTTP/2: ", err)
+		}
+		servers = append(servers, server)
+		go func() {
+			lb.LB.Logger.Println("Load Balancer started on " + cfg.TLS.Listen + " (TLS)")
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				lb.LB.Logger.Fatal("Error starting TLS server: ", err)
+			}
+		}()
+	}
+
+	// Relay raw TCP connections on the configured listeners
+	for _, listener := range cfg.TCP {
+		pool := lb.GetPool(listener.Pool)
+		proxy := lb.NewTCPProxy(pool, listener, scaler(pool))
+		listeners = append(listeners, proxy)
+		go func() {
+			if err := proxy.ListenAndServe(); err != nil {
+				pool.Logger.Fatal("Error starting TCP proxy: ", err)
+			}
+		}()
+	}
+
+	// Balance UDP datagrams on the configured listeners
+	for _, listener := range cfg.UDP {
+		pool := lb.GetPool(listener.Pool)
+		proxy := lb.NewUDPProxy(pool, listener, scaler(pool))
+		listeners = append(listeners, proxy)
+		go func() {
+			if err := proxy.ListenAndServe(); err != nil {
+				pool.Logger.Fatal("Error starting UDP proxy: ", err)
+			}
+		}()
+	}
+
+	// Accept HTTP/2 without TLS (h2c) next to HTTP/1.1 unless turned off
+	var handler http.Handler = mux
+	if h2cEnabled, err := strconv.ParseBool(os.Getenv("H2C")); err != nil || h2cEnabled {
+		handler = h2c.NewHandler(mux, &http2.Server{})
+	}
 
 	// Start the server
-	lb.LB.Logger.Println("Load Balancer started on :2000")
-	err := http.ListenAndServe(":2000", nil)
-	if err != nil {
-		lb.LB.Logger.Fatal("Error starting server: ", err)
+	server := &http.Server{Addr: ":2000", Handler: handler}
+	servers = append(servers, server)
+	go func() {
+		lb.LB.Logger.Println("Load Balancer started on :2000")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			lb.LB.Logger.Fatal("Error starting server: ", err)
+		}
+	}()
+
+	// Reload the configuration and the node files when they change or on SIGHUP.
+	// A new configuration replaces the route tables. Changes to the pools and
+	// listeners need a restart, a configuration making them is rejected.
+	reloader := lb.NewReloader(lb.LB.Logger, "config.yaml", func(next *config.Config) error {
+		if changes := restartChanges(cfg, next); len(changes) > 0 {
+			return fmt.Errorf("changes to %s cannot be applied without a restart", strings.Join(changes, ", "))
+		}
+		hosts, err := router.NewHostRouter(next, target)
+		if err != nil {
+			return fmt.Errorf("error building route table: %v", err)
+		}
+		routes.Swap(hosts)
+		cfg = next
+		return nil
+	})
+	reloader.Start(lb.EnvDuration(lb.LB.Logger, "RELOAD_INTERVAL", 5*time.Second))
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			lb.LB.Logger.Println("SIGHUP received, reloading the configuration and node files")
+			reloader.Reload()
+		}
+	}()
+
+	// Run until SIGTERM or SIGINT, then stop taking new traffic and let the
+	// outstanding requests finish before the deadline
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	<-ctx.Done()
+	stop()
+	signal.Stop(hup)
+	reloader.Stop()
+
+	timeout := lb.EnvDuration(lb.LB.Logger, "SHUTDOWN_TIMEOUT", 30*time.Second)
+	lb.LB.Logger.Printf("Shutting down, waiting up to %s for outstanding requests", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				lb.LB.Logger.Printf("Error shutting down %s: %v", server.Addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Upgraded and TCP connections are not tracked by the servers, the pools wait for them
+	for _, pool := range lb.AllPools() {
+		if err := pool.Shutdown(ctx); err != nil {
+			pool.Logger.Println("Error shutting down pool: ", err)
+		}
+	}
+	if store != nil {
+		store.Stop()
+	}
+	lb.LB.Logger.Println("Load Balancer stopped")
+}
+
+// Function to list the parts of a reloaded configuration that can only be changed on restart
+func restartChanges(previous, next *config.Config) []string {
+	var changes []string
+	if !reflect.DeepEqual(previous.Pools, next.Pools) {
+		changes = append(changes, "pools")
+	}
+	if !reflect.DeepEqual(previous.TLS, next.TLS) {
+		changes = append(changes, "tls")
+	}
+	if !reflect.DeepEqual(previous.TCP, next.TCP) {
+		changes = append(changes, "tcp")
+	}
+	if !reflect.DeepEqual(previous.UDP, next.UDP) {
+		changes = append(changes, "udp")
 	}
+	return changes
 }